@@ -0,0 +1,117 @@
+// Passkey (WebAuthn) registration and login. Binary fields are
+// exchanged with the server as base64url strings.
+(function () {
+  function toBytes(s) {
+    s = s.replace(/-/g, "+").replace(/_/g, "/");
+    var bin = atob(s + "===".slice((s.length + 3) % 4));
+    var b = new Uint8Array(bin.length);
+    for (var i = 0; i < bin.length; i++) b[i] = bin.charCodeAt(i);
+    return b.buffer;
+  }
+
+  function toBase64(buf) {
+    var b = new Uint8Array(buf);
+    var bin = "";
+    for (var i = 0; i < b.length; i++) bin += String.fromCharCode(b[i]);
+    return btoa(bin).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+  }
+
+  function post(url, body) {
+    return fetch(url, {
+      method: "POST",
+      credentials: "same-origin",
+      headers: { "Content-Type": "application/json" },
+      body: body ? JSON.stringify(body) : null,
+    }).then(function (res) {
+      return res.json().then(function (data) {
+        if (!res.ok) throw new Error(data.error || "Something went wrong.");
+        return data;
+      });
+    });
+  }
+
+  function showError(el, err) {
+    el.textContent = err.message;
+    el.classList.remove("hidden");
+  }
+
+  function register(name, errEl) {
+    post("/webauthn/register/begin")
+      .then(function (opts) {
+        var pk = opts.publicKey;
+        pk.challenge = toBytes(pk.challenge);
+        pk.user.id = toBytes(pk.user.id);
+        pk.excludeCredentials.forEach(function (c) {
+          c.id = toBytes(c.id);
+        });
+        return navigator.credentials.create(opts);
+      })
+      .then(function (cred) {
+        return post("/webauthn/register/finish", {
+          name: name,
+          credential: {
+            rawId: toBase64(cred.rawId),
+            type: cred.type,
+            response: {
+              clientDataJSON: toBase64(cred.response.clientDataJSON),
+              attestationObject: toBase64(cred.response.attestationObject),
+            },
+          },
+        });
+      })
+      .then(function (data) {
+        window.location = data.redirect;
+      })
+      .catch(function (err) {
+        showError(errEl, err);
+      });
+  }
+
+  function login(errEl) {
+    post("/webauthn/login/begin")
+      .then(function (opts) {
+        opts.publicKey.challenge = toBytes(opts.publicKey.challenge);
+        return navigator.credentials.get(opts);
+      })
+      .then(function (cred) {
+        var r = cred.response;
+        return post("/webauthn/login/finish", {
+          rawId: toBase64(cred.rawId),
+          type: cred.type,
+          response: {
+            clientDataJSON: toBase64(r.clientDataJSON),
+            authenticatorData: toBase64(r.authenticatorData),
+            signature: toBase64(r.signature),
+            userHandle: r.userHandle ? toBase64(r.userHandle) : "",
+          },
+        });
+      })
+      .then(function (data) {
+        window.location = data.redirect;
+      })
+      .catch(function (err) {
+        showError(errEl, err);
+      });
+  }
+
+  document.addEventListener("DOMContentLoaded", function () {
+    var errEl = document.getElementById("passkey-error");
+    if (!window.PublicKeyCredential) return;
+
+    var loginBtn = document.getElementById("passkey-login");
+    if (loginBtn) {
+      loginBtn.classList.remove("hidden");
+      loginBtn.addEventListener("click", function () {
+        login(errEl);
+      });
+    }
+
+    var form = document.getElementById("passkey-register");
+    if (form) {
+      form.addEventListener("submit", function (e) {
+        e.preventDefault();
+        register(form.elements.name.value, errEl);
+      });
+    }
+  });
+})();
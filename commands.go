@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"soramon0/webapp/lib"
+	"soramon0/webapp/models"
+	seeder "soramon0/webapp/seed"
+)
+
+// cliActor is the actor of the changes made from the CLI in
+// the audit log.
+var cliActor = models.Actor{RequestID: "cli"}
+
+// generatedPasswordBytes is the size of the random passwords
+// given to users created or reset from the CLI.
+const generatedPasswordBytes = 18
+
+// createUser creates a user with a random password, which is
+// printed once.
+func createUser(args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	name := fs.String("name", "", "name of the user")
+	email := fs.String("email", "", "email address of the user, required")
+	role := fs.String("role", models.RoleUser, "role of the user: user, moderator or admin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("create-user needs -email")
+	}
+
+	password, err := lib.Base64FromBytes(generatedPasswordBytes)
+	if err != nil {
+		return err
+	}
+
+	s := models.NewServices()
+	defer s.Close()
+	ctx := context.Background()
+	user := models.User{
+		Name:     *name,
+		Email:    *email,
+		Role:     *role,
+		Password: password,
+	}
+	if err := s.User.As(cliActor).Create(ctx, &user); err != nil {
+		return err
+	}
+
+	fmt.Printf("created user %d %s with the role %s\npassword: %s\n", user.ID, user.Email, user.Role, password)
+	return nil
+}
+
+// resetPassword sets a new random password, which is printed
+// once, unlocks the account and signs out all its sessions.
+func resetPassword(args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	email := fs.String("email", "", "email address of the user, required")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s := models.NewServices()
+	defer s.Close()
+	ctx := context.Background()
+	user, err := userByEmail(ctx, s, *email)
+	if err != nil {
+		return err
+	}
+
+	password, err := lib.Base64FromBytes(generatedPasswordBytes)
+	if err != nil {
+		return err
+	}
+	token, err := lib.RememberToken()
+	if err != nil {
+		return err
+	}
+
+	user.Password = password
+	user.Remember = token
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	if err := s.User.As(cliActor).Update(ctx, user); err != nil {
+		return err
+	}
+
+	fmt.Printf("reset the password of user %d %s\npassword: %s\n", user.ID, user.Email, password)
+	return nil
+}
+
+// promote changes the role of a user, to admin by default.
+func promote(args []string) error {
+	fs := flag.NewFlagSet("promote", flag.ContinueOnError)
+	email := fs.String("email", "", "email address of the user, required")
+	role := fs.String("role", models.RoleAdmin, "new role of the user: user, moderator or admin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s := models.NewServices()
+	defer s.Close()
+	ctx := context.Background()
+	user, err := userByEmail(ctx, s, *email)
+	if err != nil {
+		return err
+	}
+
+	if err := s.User.As(cliActor).SetRole(ctx, user, *role); err != nil {
+		return err
+	}
+
+	fmt.Printf("user %d %s is now %s\n", user.ID, user.Email, user.Role)
+	return nil
+}
+
+func userByEmail(ctx context.Context, s *models.Services, email string) (*models.User, error) {
+	if email == "" {
+		return nil, errors.New("-email is required")
+	}
+
+	user, err := s.User.ByEmail(ctx, email)
+	if err == models.ErrNotFound {
+		return nil, fmt.Errorf("no user with the email address %s", email)
+	}
+	return user, err
+}
+
+// reset drops all the data, it needs the -confirm flag.
+func reset(args []string) error {
+	fs := flag.NewFlagSet("reset", flag.ContinueOnError)
+	confirm := fs.Bool("confirm", false, "confirm dropping all the data")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*confirm {
+		return errors.New("reset drops all the data, run it again with -confirm")
+	}
+
+	s := models.NewServices()
+	defer s.Close()
+	if err := s.DestructiveReset(); err != nil {
+		return err
+	}
+
+	fmt.Println("dropped all the data and migrated again")
+	return nil
+}
+
+// seed creates users, galleries and placeholder images for
+// development.
+func seed(args []string) error {
+	cfg := seeder.DefaultConfig
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fs.IntVar(&cfg.Users, "users", cfg.Users, "number of users")
+	fs.IntVar(&cfg.GalleriesPerUser, "galleries", cfg.GalleriesPerUser, "number of galleries per user")
+	fs.IntVar(&cfg.ImagesPerGallery, "images", cfg.ImagesPerGallery, "number of images per gallery")
+	fs.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed, the same seed generates the same data")
+	fs.StringVar(&cfg.Password, "password", seeder.DefaultPassword, "password of the users")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s := models.NewServices()
+	defer s.Close()
+	ctx := context.Background()
+	sd := seeder.New(s.User.As(cliActor), s.Gallery.As(cliActor), s.Image.As(cliActor), cfg)
+	res, err := sd.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created %d users, %d galleries and %d images with the seed %d\n",
+		len(res.Users), len(res.Galleries), res.Images, cfg.Seed)
+	for _, u := range res.Users {
+		fmt.Println(u.Email)
+	}
+	fmt.Printf("password: %s\n", cfg.Password)
+	return nil
+}
+
+// reindexImages checks the images on disk against the galleries,
+// moves the images of the galleries in the trash there, and with
+// -prune deletes the images of missing galleries.
+func reindexImages(args []string) error {
+	fs := flag.NewFlagSet("reindex-images", flag.ContinueOnError)
+	prune := fs.Bool("prune", false, "delete the images of galleries that don't exist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s := models.NewServices()
+	defer s.Close()
+	ctx := context.Background()
+	ids, err := s.Image.GalleryIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	var images, orphans int
+	for _, id := range ids {
+		imgs, err := s.Image.ByGalleryID(ctx, id)
+		if err != nil {
+			return err
+		}
+		images += len(imgs)
+
+		_, err = s.Gallery.ByID(ctx, id)
+		if err == nil {
+			continue
+		}
+		if err != models.ErrNotFound {
+			return err
+		}
+
+		// Galleries deleted before the trash kept their images
+		// in place, where they are still served.
+		if _, err := s.Gallery.DeletedByID(ctx, id); err == nil {
+			if err := s.Image.Trash(ctx, id); err != nil {
+				return err
+			}
+			fmt.Printf("gallery %d is in the trash, moved its %d images there\n", id, len(imgs))
+			continue
+		}
+
+		orphans++
+		if !*prune {
+			fmt.Printf("gallery %d doesn't exist, it has %d images\n", id, len(imgs))
+			continue
+		}
+		if err := s.Image.DeleteAll(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("gallery %d doesn't exist, deleted its %d images\n", id, len(imgs))
+	}
+
+	fmt.Printf("%d galleries with %d images on disk, %d without a gallery\n", len(ids), images, orphans)
+	if orphans > 0 && !*prune {
+		fmt.Println("run it again with -prune to delete them")
+	}
+	return nil
+}
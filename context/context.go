@@ -7,7 +7,10 @@ import (
 )
 
 const (
-	userKey = privateKey("user")
+	userKey     = privateKey("user")
+	adminKey    = privateKey("impersonator")
+	cspNonceKey = privateKey("csp-nonce")
+	requestKey  = privateKey("request-id")
 )
 
 type privateKey string
@@ -25,3 +28,55 @@ func User(ctx context.Context) *models.User {
 
 	return nil
 }
+
+// WithImpersonator stores the admin acting as the user
+// of the request.
+func WithImpersonator(ctx context.Context, admin *models.User) context.Context {
+	return context.WithValue(ctx, adminKey, admin)
+}
+
+// Impersonator returns the admin acting as the user of the
+// request, or nil if the user is not impersonated.
+func Impersonator(ctx context.Context) *models.User {
+	if tmp := ctx.Value(adminKey); tmp != nil {
+		if u, ok := tmp.(*models.User); ok {
+			return u
+		}
+	}
+
+	return nil
+}
+
+func WithCSPNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, cspNonceKey, nonce)
+}
+
+// CSPNonce returns the Content-Security-Policy nonce for
+// the current request, or an empty string if there is none.
+func CSPNonce(ctx context.Context) string {
+	if tmp := ctx.Value(cspNonceKey); tmp != nil {
+		if n, ok := tmp.(string); ok {
+			return n
+		}
+	}
+
+	return ""
+}
+
+// WithRequestID stores the ID of the request, to match
+// the logs and audit events of a request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey, id)
+}
+
+// RequestID returns the ID of the current request, or an
+// empty string if there is none.
+func RequestID(ctx context.Context) string {
+	if tmp := ctx.Value(requestKey); tmp != nil {
+		if id, ok := tmp.(string); ok {
+			return id
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"net/http"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+	"soramon0/webapp/models"
+	"soramon0/webapp/views"
+)
+
+const (
+	AccountURL         = "account"
+	AccountUpdateURL   = "account_update"
+	AccountPasswordURL = "account_password"
+	AccountDeleteURL   = "account_delete"
+)
+
+// AccountData is the data of the account settings page.
+type AccountData struct {
+	Name  string
+	Email string
+	// HasPassword is false for accounts created through an
+	// identity provider, they can set a password without
+	// entering the current one.
+	HasPassword bool
+}
+
+func accountData(user *models.User) AccountData {
+	return AccountData{
+		Name:        user.Name,
+		Email:       user.Email,
+		HasPassword: user.PasswordHash != "",
+	}
+}
+
+// Account is used to render the account settings page.
+//
+// GET /account
+func (u *Users) Account(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	vd := views.Data{Yield: accountData(user)}
+	u.AccountView.Render(w, r, vd)
+}
+
+type AccountForm struct {
+	Name            string `schema:"name"`
+	Email           string `schema:"email,required"`
+	CurrentPassword string `schema:"current_password"`
+}
+
+// UpdateAccount is used to change the name and email address
+// of the current user. Changing the email address requires the
+// current password and signs out the other sessions, it is not
+// allowed while impersonating the user.
+//
+// POST /account
+func (u *Users) UpdateAccount(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	vd := views.Data{Yield: accountData(user)}
+	var form AccountForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	vd.Yield = AccountData{
+		Name:        form.Name,
+		Email:       form.Email,
+		HasPassword: user.PasswordHash != "",
+	}
+
+	emailChanged := models.NormalizeEmail(form.Email) != user.Email
+	if emailChanged && impersonating(r) {
+		vd.SetAlert(errImpersonating)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+	if emailChanged {
+		if err := u.confirmPassword(r, user, form.CurrentPassword); err != nil {
+			vd.SetAlert(err)
+			u.AccountView.Render(w, r, vd)
+			return
+		}
+	}
+
+	user.Name = form.Name
+	user.Email = form.Email
+	if emailChanged {
+		if err := u.rotateRemember(user); err != nil {
+			vd.SetAlert(err)
+			u.AccountView.Render(w, r, vd)
+			return
+		}
+		// A magic link sent to the old address must not sign
+		// in to the account anymore.
+		user.MagicLinkHash = ""
+		user.MagicLinkExpiresAt = nil
+	}
+
+	if err := u.us.As(actor(r)).Update(r.Context(), user); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if emailChanged {
+		// The current session gets the new token, it is not a
+		// new sign in.
+		setRememberCookie(w, user)
+	}
+
+	vd.Yield = accountData(user)
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLevelSucess,
+		Message: "Account successfully updated!",
+	}
+	u.AccountView.Render(w, r, vd)
+}
+
+type PasswordForm struct {
+	CurrentPassword string `schema:"current_password"`
+	Password        string `schema:"password,required"`
+	Confirmation    string `schema:"password_confirmation,required"`
+}
+
+// ChangePassword is used to change the password of the
+// current user. It signs out the other sessions, and is not
+// allowed while impersonating the user.
+//
+// POST /account/password
+func (u *Users) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	vd := views.Data{Yield: accountData(user)}
+	var form PasswordForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if impersonating(r) {
+		vd.SetAlert(errImpersonating)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if form.Password != form.Confirmation {
+		vd.SetAlert(errPasswordMismatch)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if err := u.confirmPassword(r, user, form.CurrentPassword); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	user.Password = form.Password
+	if err := u.rotateRemember(user); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if err := u.us.As(actor(r)).Update(r.Context(), user); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	// The current session gets the new token, it is not a
+	// new sign in.
+	setRememberCookie(w, user)
+
+	vd.Yield = accountData(user)
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLevelSucess,
+		Message: "Password successfully changed!",
+	}
+	u.AccountView.Render(w, r, vd)
+}
+
+type DeleteAccountForm struct {
+	CurrentPassword string `schema:"current_password"`
+	Email           string `schema:"email"`
+}
+
+// AccountDeletedData is the data of the page shown once
+// the account deletion is scheduled.
+type AccountDeletedData struct {
+	DeleteAfter string
+}
+
+// DeleteAccount is used to schedule the deletion of the current
+// user account, after confirming the password, or the email
+// address for accounts without a password. The user is signed
+// out and can cancel the deletion by signing in again during
+// the grace period. Admins impersonating the user can't.
+//
+// POST /account/delete
+func (u *Users) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	vd := views.Data{Yield: accountData(user)}
+	var form DeleteAccountForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if impersonating(r) {
+		vd.SetAlert(errImpersonating)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if user.PasswordHash != "" {
+		if err := u.us.VerifyPassword(r.Context(), user, form.CurrentPassword); err != nil {
+			vd.SetAlert(err)
+			u.AccountView.Render(w, r, vd)
+			return
+		}
+	} else if models.NormalizeEmail(form.Email) != user.Email {
+		vd.SetAlert(errDeleteConfirmation)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if err := u.us.ScheduleDeletion(r.Context(), user); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	u.l.Printf("user %d scheduled the deletion of their account\n", user.ID)
+	clearCookie(w, "remember_token", "/")
+
+	r = r.WithContext(context.WithUser(r.Context(), nil))
+	vd = views.Data{Yield: AccountDeletedData{
+		DeleteAfter: user.DeleteAfter.Format("January 2, 2006"),
+	}}
+	u.AccountDeletedView.Render(w, r, vd)
+}
+
+// impersonating reports whether an admin is acting as the
+// user of the request.
+func impersonating(r *http.Request) bool {
+	return context.Impersonator(r.Context()) != nil
+}
+
+// confirmPassword checks the current password of user before
+// a sensitive change. Accounts without a password have
+// nothing to confirm.
+func (u *Users) confirmPassword(r *http.Request, user *models.User, password string) error {
+	err := u.us.VerifyPassword(r.Context(), user, password)
+	if err == models.ErrPasswordNotSet {
+		return nil
+	}
+
+	return err
+}
+
+// rotateRemember sets a new remember token on user, so the
+// sessions using the previous one are signed out once the
+// user is updated.
+func (u *Users) rotateRemember(user *models.User) error {
+	token, err := lib.RememberToken()
+	if err != nil {
+		return err
+	}
+
+	user.Remember = token
+	return nil
+}
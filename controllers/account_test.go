@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	stdcontext "context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+	"soramon0/webapp/models"
+	"soramon0/webapp/utils"
+)
+
+// testingPassword is the password of the user of testingUsers.
+const testingPassword = "kq7!Vd2#pLm9"
+
+// userRequest returns a POST request of user with the form.
+func userRequest(target string, form url.Values, user *models.User) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r.WithContext(context.WithUser(r.Context(), user))
+}
+
+// signedInUser returns user as loaded by the user middleware,
+// with a known remember token.
+func signedInUser(t *testing.T, s *models.Services, user *models.User) (*models.User, string) {
+	token, err := lib.RememberToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Remember = token
+	if err := s.User.Update(stdcontext.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.User.ByRemember(stdcontext.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got, token
+}
+
+func TestUpdateAccountEmailTaken(t *testing.T) {
+	uc, s, user := testingUsers(t)
+	ctx := stdcontext.Background()
+	other := models.User{Name: "Alex Kim", Email: "alex@test.com", Password: "vB3#nQ8!zRt5"}
+	if err := s.User.Create(ctx, &other); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := signedInUser(t, s, user)
+
+	form := url.Values{
+		"name":             {current.Name},
+		"email":            {"Alex@test.com"},
+		"current_password": {testingPassword},
+	}
+	w := httptest.NewRecorder()
+	uc.UpdateAccount(w, userRequest("/account", form, current))
+	if !strings.Contains(w.Body.String(), models.ErrEmailTaken.Public()) {
+		t.Error("Expected the email address to be taken")
+	}
+
+	got, err := s.User.ByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != user.Email {
+		t.Errorf("Email = %q, want %q", got.Email, user.Email)
+	}
+}
+
+func TestAccountCurrentPassword(t *testing.T) {
+	uc, s, user := testingUsers(t)
+	ctx := stdcontext.Background()
+
+	handlers := map[string]struct {
+		h    http.HandlerFunc
+		form url.Values
+	}{
+		"/account": {uc.UpdateAccount, url.Values{
+			"name":  {user.Name},
+			"email": {"sam.lee@test.com"},
+		}},
+		"/account/password": {uc.ChangePassword, url.Values{
+			"password":              {"vB3#nQ8!zRt5"},
+			"password_confirmation": {"vB3#nQ8!zRt5"},
+		}},
+		"/account/delete": {uc.DeleteAccount, url.Values{}},
+	}
+
+	current, _ := signedInUser(t, s, user)
+	for target, tc := range handlers {
+		w := httptest.NewRecorder()
+		tc.h(w, userRequest(target, tc.form, current))
+		if !strings.Contains(w.Body.String(), models.ErrPasswordInccorect.Public()) {
+			t.Errorf("%s: expected the incorrect password alert", target)
+		}
+	}
+
+	got, err := s.User.ByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != user.Email || got.DeleteAfter != nil || got.PasswordHash != current.PasswordHash {
+		t.Error("Expected the account to be left as is")
+	}
+	// The wrong guesses count towards the login lockout.
+	if got.FailedLogins != len(handlers) {
+		t.Errorf("FailedLogins = %d, want %d", got.FailedLogins, len(handlers))
+	}
+
+	form := url.Values{"current_password": {"wrong password"}}
+	for i := len(handlers); i < utils.GetLoginMaxFailures(); i++ {
+		uc.DeleteAccount(httptest.NewRecorder(), userRequest("/account/delete", form, current))
+	}
+	_, err = s.User.Authenticate(ctx, user.Email, testingPassword)
+	if _, ok := err.(models.LockedError); !ok {
+		t.Errorf("Authenticate err = %v, want LockedError", err)
+	}
+}
+
+func TestAccountRotatesRemember(t *testing.T) {
+	uc, s, user := testingUsers(t)
+	ctx := stdcontext.Background()
+
+	handlers := map[string]struct {
+		h    http.HandlerFunc
+		form url.Values
+	}{
+		"/account": {uc.UpdateAccount, url.Values{
+			"name":             {user.Name},
+			"email":            {"sam.lee@test.com"},
+			"current_password": {testingPassword},
+		}},
+		"/account/password": {uc.ChangePassword, url.Values{
+			"current_password":      {testingPassword},
+			"password":              {"vB3#nQ8!zRt5"},
+			"password_confirmation": {"vB3#nQ8!zRt5"},
+		}},
+	}
+
+	for target, tc := range handlers {
+		current, old := signedInUser(t, s, user)
+		w := httptest.NewRecorder()
+		tc.h(w, userRequest(target, tc.form, current))
+
+		var token string
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "remember_token" {
+				token = c.Value
+			}
+		}
+		if token == "" || token == old {
+			t.Errorf("%s: expected a new remember token cookie", target)
+			continue
+		}
+		if _, err := s.User.ByRemember(ctx, old); err != models.ErrNotFound {
+			t.Errorf("%s: expected the other sessions to be signed out. Recieved %v", target, err)
+		}
+		if _, err := s.User.ByRemember(ctx, token); err != nil {
+			t.Errorf("%s: expected the current session to stay signed in. Recieved %v", target, err)
+		}
+		user = current
+	}
+}
@@ -0,0 +1,503 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+	"soramon0/webapp/models"
+	"soramon0/webapp/policy"
+	"soramon0/webapp/views"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	AdminURL          = "admin"
+	AdminUsersURL     = "admin_users"
+	AdminGalleriesURL = "admin_galleries"
+	AdminAuditURL     = "admin_audit"
+
+	adminPageSize = 20
+	// impersonationTTL is how long an admin can act as
+	// another user before having to start again.
+	impersonationTTL = time.Hour
+)
+
+// NewAdmin is used to create a new Admin controller. It uses
+// the Users controller for the accounts and the MagicLinks
+// controller to email a login link after a password reset.
+// This function will panic if the templates are not
+// parsed correctly, and should only be used during
+// initial setup.
+func NewAdmin(uc *Users, ml *MagicLinks, gs models.GalleryService, is models.ImageService, as models.AuditService, t models.Transactor, r *mux.Router, l *log.Logger) *Admin {
+	return &Admin{
+		DashboardView: views.NewView("bootstrap", "admin/dashboard"),
+		UsersView:     views.NewView("bootstrap", "admin/users"),
+		GalleriesView: views.NewView("bootstrap", "admin/galleries"),
+		AuditView:     views.NewView("bootstrap", "admin/audit"),
+		uc:            uc,
+		ml:            ml,
+		gs:            gs,
+		is:            is,
+		as:            as,
+		t:             t,
+		r:             r,
+		l:             l,
+	}
+}
+
+type Admin struct {
+	DashboardView *views.View
+	UsersView     *views.View
+	GalleriesView *views.View
+	AuditView     *views.View
+	uc            *Users
+	ml            *MagicLinks
+	gs            models.GalleryService
+	is            models.ImageService
+	as            models.AuditService
+	t             models.Transactor
+	r             *mux.Router
+	l             *log.Logger
+}
+
+// DashboardData is the data of the admin dashboard.
+type DashboardData struct {
+	Users           int64
+	Galleries       int64
+	Storage         string
+	RecentUsers     []models.User
+	RecentGalleries []models.Gallery
+}
+
+// Dashboard is used to show an overview of the users,
+// galleries and storage.
+//
+// GET /admin
+func (a *Admin) Dashboard(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	users, userCount, err := a.uc.us.Search(r.Context(), "", 5, 0)
+	if err != nil {
+		vd.SetAlert(err)
+		a.DashboardView.Render(w, r, vd)
+		return
+	}
+
+	galleries, galleryCount, err := a.gs.List(r.Context(), 0, 5, 0)
+	if err != nil {
+		vd.SetAlert(err)
+		a.DashboardView.Render(w, r, vd)
+		return
+	}
+
+	usage, err := a.is.Usage(r.Context(), 0)
+	if err != nil {
+		vd.SetAlert(err)
+		a.DashboardView.Render(w, r, vd)
+		return
+	}
+
+	vd.Yield = DashboardData{
+		Users:           userCount,
+		Galleries:       galleryCount,
+		Storage:         humanizeBytes(usage),
+		RecentUsers:     users,
+		RecentGalleries: galleries,
+	}
+	a.DashboardView.Render(w, r, vd)
+}
+
+// humanizeBytes formats a size in bytes, e.g. 1.5 MB.
+func humanizeBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Pagination links the pages of a list.
+type Pagination struct {
+	Page    int
+	Pages   int
+	Total   int64
+	PrevURL string
+	NextURL string
+}
+
+// paginate reads the page query parameter and returns the
+// offset of its first item. It must be completed with the
+// total number of items by withTotal.
+func paginate(r *http.Request) (Pagination, int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	return Pagination{Page: page}, (page - 1) * adminPageSize
+}
+
+// withTotal sets the number of pages and the links to the
+// previous and next pages, keeping the other query parameters.
+func (p Pagination) withTotal(r *http.Request, total int64) Pagination {
+	p.Total = total
+	p.Pages = int((total + adminPageSize - 1) / adminPageSize)
+
+	link := func(page int) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(page))
+		return r.URL.Path + "?" + q.Encode()
+	}
+	if p.Page > 1 {
+		p.PrevURL = link(p.Page - 1)
+	}
+	if p.Page < p.Pages {
+		p.NextURL = link(p.Page + 1)
+	}
+
+	return p
+}
+
+// UsersData is the data of the admin users page.
+type UsersData struct {
+	Query      string
+	Users      []models.User
+	Pagination Pagination
+}
+
+// Users is used to search the users.
+//
+// GET /admin/users?q=&page=
+func (a *Admin) Users(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	query := r.URL.Query().Get("q")
+	p, offset := paginate(r)
+	users, total, err := a.uc.us.Search(r.Context(), query, adminPageSize, offset)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+
+	vd.Yield = UsersData{
+		Query:      query,
+		Users:      users,
+		Pagination: p.withTotal(r, total),
+	}
+	a.UsersView.Render(w, r, vd)
+}
+
+// AdminGallery is a gallery as listed on the admin
+// galleries page.
+type AdminGallery struct {
+	models.Gallery
+	Images  int
+	Storage string
+}
+
+// GalleriesData is the data of the admin galleries page.
+type GalleriesData struct {
+	UserID     uint
+	Galleries  []AdminGallery
+	Pagination Pagination
+}
+
+// Galleries is used to list the galleries of everyone,
+// or of a single user.
+//
+// GET /admin/galleries?user=&page=
+func (a *Admin) Galleries(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	vd.Yield = GalleriesData{}
+	userID, _ := strconv.Atoi(r.URL.Query().Get("user"))
+	p, offset := paginate(r)
+	galleries, total, err := a.gs.List(r.Context(), uint(userID), adminPageSize, offset)
+	if err != nil {
+		vd.SetAlert(err)
+		a.GalleriesView.Render(w, r, vd)
+		return
+	}
+
+	items := make([]AdminGallery, len(galleries))
+	for i, g := range galleries {
+		images, err := a.is.ByGalleryID(r.Context(), g.ID)
+		if err != nil {
+			vd.SetAlert(err)
+			a.GalleriesView.Render(w, r, vd)
+			return
+		}
+		usage, err := a.is.Usage(r.Context(), g.ID)
+		if err != nil {
+			vd.SetAlert(err)
+			a.GalleriesView.Render(w, r, vd)
+			return
+		}
+		items[i] = AdminGallery{Gallery: g, Images: len(images), Storage: humanizeBytes(usage)}
+	}
+
+	vd.Yield = GalleriesData{
+		UserID:     uint(userID),
+		Galleries:  items,
+		Pagination: p.withTotal(r, total),
+	}
+	a.GalleriesView.Render(w, r, vd)
+}
+
+// AuditData is the data of the admin audit log page.
+type AuditData struct {
+	Filter     models.AuditFilter
+	Actions    []string
+	Events     []models.AuditEvent
+	Pagination Pagination
+}
+
+// Audit is used to browse the audit log, filtered by actor,
+// action or target.
+//
+// GET /admin/audit?actor=&action=&target_type=&target_id=&page=
+func (a *Admin) Audit(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	q := r.URL.Query()
+	actorID, _ := strconv.Atoi(q.Get("actor"))
+	targetID, _ := strconv.Atoi(q.Get("target_id"))
+	filter := models.AuditFilter{
+		ActorID:    uint(actorID),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   uint(targetID),
+	}
+
+	p, offset := paginate(r)
+	events, total, err := a.as.Search(r.Context(), filter, adminPageSize, offset)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+
+	vd.Yield = AuditData{
+		Filter:     filter,
+		Actions:    models.AuditActions,
+		Events:     events,
+		Pagination: p.withTotal(r, total),
+	}
+	a.AuditView.Render(w, r, vd)
+}
+
+// DisableUser is used to disable an account and sign out
+// all of its sessions.
+//
+// POST /admin/users/:id/disable
+func (a *Admin) DisableUser(w http.ResponseWriter, r *http.Request) {
+	a.updateUser(w, r, models.AuditUserDisable, func(user *models.User) error {
+		if user.IsDisabled() {
+			return nil
+		}
+
+		now := time.Now()
+		user.DisabledAt = &now
+		return a.uc.rotateRemember(user)
+	})
+}
+
+// EnableUser is used to enable a disabled account.
+//
+// POST /admin/users/:id/enable
+func (a *Admin) EnableUser(w http.ResponseWriter, r *http.Request) {
+	a.updateUser(w, r, models.AuditUserEnable, func(user *models.User) error {
+		user.DisabledAt = nil
+		return nil
+	})
+}
+
+// ResetPassword is used to force a password reset. The password
+// is removed, all sessions are signed out and the user is
+// emailed a login link to set a new password.
+//
+// POST /admin/users/:id/reset-password
+func (a *Admin) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	a.updateUser(w, r, models.AuditUserPasswordReset, func(user *models.User) error {
+		user.PasswordHash = ""
+		return a.uc.rotateRemember(user)
+	}, func(user *models.User) error {
+		return a.ml.send(r, user.Email)
+	})
+}
+
+// updateUser applies fn to the user of the request, saves it,
+// runs the after funcs and audit logs action.
+func (a *Admin) updateUser(w http.ResponseWriter, r *http.Request, action string, fn func(*models.User) error, after ...func(*models.User) error) {
+	user, err := a.userByID(r)
+	if err != nil {
+		a.renderUsersError(w, r, err)
+		return
+	}
+
+	if err := fn(user); err != nil {
+		a.renderUsersError(w, r, err)
+		return
+	}
+	if err := a.uc.us.Update(r.Context(), user); err != nil {
+		a.renderUsersError(w, r, err)
+		return
+	}
+	for _, fn := range after {
+		if err := fn(user); err != nil {
+			a.renderUsersError(w, r, err)
+			return
+		}
+	}
+
+	a.audit(r, action, "user", user.ID)
+	a.redirectBack(w, r, Reverse(AdminUsersURL, "/admin/users", a.r))
+}
+
+// userByID returns the user of the id route variable, if the
+// admin may manage it. Admins can't manage themselves here,
+// so they don't lock themselves out.
+func (a *Admin) userByID(r *http.Request) (*models.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, models.ErrNotFound
+	}
+
+	admin := context.User(r.Context())
+	user, err := a.uc.us.ByID(r.Context(), uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == admin.ID || !policy.Can(admin, policy.Manage, user) {
+		return nil, errAdminForbidden
+	}
+
+	return user, nil
+}
+
+func (a *Admin) renderUsersError(w http.ResponseWriter, r *http.Request, err error) {
+	var vd views.Data
+	vd.SetAlert(err)
+	vd.Yield = UsersData{}
+	a.UsersView.Render(w, r, vd)
+}
+
+// DeleteGallery is used to move any gallery to the trash,
+// the owner can restore it until it is purged.
+//
+// POST /admin/galleries/:id/delete
+func (a *Admin) DeleteGallery(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	vd.Yield = GalleriesData{}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		vd.SetAlert(models.ErrNotFound)
+		a.GalleriesView.Render(w, r, vd)
+		return
+	}
+
+	gallery, err := a.gs.ByID(r.Context(), uint(id))
+	if err != nil {
+		vd.SetAlert(err)
+		a.GalleriesView.Render(w, r, vd)
+		return
+	}
+
+	if !policy.Can(context.User(r.Context()), policy.Delete, gallery) {
+		vd.SetAlert(errAdminForbidden)
+		a.GalleriesView.Render(w, r, vd)
+		return
+	}
+
+	if err := deleteGallery(r, a.t, gallery.ID); err != nil {
+		vd.SetAlert(err)
+		a.GalleriesView.Render(w, r, vd)
+		return
+	}
+
+	a.redirectBack(w, r, Reverse(AdminGalleriesURL, "/admin/galleries", a.r))
+}
+
+// Impersonate is used to act as another user for support,
+// until StopImpersonating or for impersonationTTL.
+//
+// POST /admin/users/:id/impersonate
+func (a *Admin) Impersonate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		a.renderUsersError(w, r, models.ErrNotFound)
+		return
+	}
+
+	admin := context.User(r.Context())
+	user, err := a.uc.us.ByID(r.Context(), uint(id))
+	if err != nil {
+		a.renderUsersError(w, r, err)
+		return
+	}
+	if !policy.Can(admin, policy.Impersonate, user) {
+		a.renderUsersError(w, r, errAdminForbidden)
+		return
+	}
+
+	expires := time.Now().Add(impersonationTTL)
+	c := http.Cookie{
+		Name: lib.ImpersonationCookie,
+		Value: a.uc.hmac.SignImpersonation(lib.Impersonation{
+			AdminID:   admin.ID,
+			UserID:    user.ID,
+			ExpiresAt: expires,
+		}),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &c)
+
+	a.audit(r, models.AuditUserImpersonate, "user", user.ID)
+	path := Reverse(GalleriesIndexURL, "/", a.r)
+	http.Redirect(w, r, path, http.StatusFound)
+}
+
+// StopImpersonating is used by an admin to go back to
+// their own account.
+//
+// POST /impersonate/stop
+func (a *Admin) StopImpersonating(w http.ResponseWriter, r *http.Request) {
+	clearCookie(w, lib.ImpersonationCookie, "/")
+
+	if context.Impersonator(r.Context()) == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	a.audit(r, models.AuditUserImpersonateStop, "user", context.User(r.Context()).ID)
+	path := Reverse(AdminUsersURL, "/admin/users", a.r)
+	http.Redirect(w, r, path, http.StatusFound)
+}
+
+// audit records an admin action on a target. The action is
+// already done, so failing to record it is only logged.
+func (a *Admin) audit(r *http.Request, action, targetType string, targetID uint) {
+	if err := a.as.Record(r.Context(), actor(r).Event(action, targetType, targetID)); err != nil {
+		a.l.Println(err)
+	}
+}
+
+// redirectBack redirects to the admin page the request came
+// from, keeping its search and page, or to fallback.
+func (a *Admin) redirectBack(w http.ResponseWriter, r *http.Request, fallback string) {
+	path := fallback
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host && ref.Path == fallback {
+		path = ref.RequestURI()
+	}
+
+	http.Redirect(w, r, path, http.StatusFound)
+}
@@ -1,5 +1,19 @@
 package controllers
 
+const (
+	errTwoFactorExpired   = parseError("Your login session expired, please log in again.")
+	errPasskeyExpired     = parseError("Your passkey session expired, please try again.")
+	errPasskeyInvalid     = parseError("This passkey could not be verified.")
+	errSSOExpired         = parseError("Your single sign-on session expired, please try again.")
+	errSSOFailed          = parseError("Single sign-on failed, please try again.")
+	errPasswordMismatch   = parseError("The new password and its confirmation do not match.")
+	errExportPending      = parseError("An export is already being prepared, we will email you when it is ready.")
+	errDeleteConfirmation = parseError("Please enter your email address to confirm the deletion of your account.")
+	errSSOEmailTaken      = parseError("An account with this email address already exists. Log in and sign in with your provider again to link it.")
+	errAdminForbidden     = parseError("You are not allowed to do this to that account.")
+	errImpersonating      = parseError("The sign-in methods, email address, data exports and deletion of an account are off limits while impersonating it.")
+)
+
 type parseError string
 
 func (e parseError) Error() string {
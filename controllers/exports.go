@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	stdcontext "context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/email"
+	"soramon0/webapp/jobs"
+	"soramon0/webapp/models"
+	"soramon0/webapp/policy"
+	"soramon0/webapp/utils"
+	"soramon0/webapp/views"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ExportsIndexURL   = "exports_index"
+	ExportCreateURL   = "export_create"
+	ExportDownloadURL = "export_download"
+)
+
+// NewExports is used to create a new Exports controller.
+// Exports are built in the background by jr, and the user
+// is emailed through m once they are ready.
+// This function will panic if the templates are not
+// parsed correctly, and should only be used during
+// initial setup.
+func NewExports(es models.ExportService, jr *jobs.Runner, m email.Mailer, r *mux.Router, l *log.Logger) *Exports {
+	return &Exports{
+		IndexView: views.NewView("bootstrap", "exports/index"),
+		es:        es,
+		jr:        jr,
+		m:         m,
+		r:         r,
+		l:         l,
+	}
+}
+
+type Exports struct {
+	IndexView *views.View
+	es        models.ExportService
+	jr        *jobs.Runner
+	m         email.Mailer
+	r         *mux.Router
+	l         *log.Logger
+}
+
+// ExportItem is an export as listed on the exports page.
+type ExportItem struct {
+	CreatedAt   time.Time
+	Status      string
+	ExpiresAt   *time.Time
+	DownloadURL string
+}
+
+// Index is used to list the data exports of the user.
+//
+// GET /account/export
+func (e *Exports) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var vd views.Data
+	items, err := e.items(r, user)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+
+	vd.Yield = items
+	e.IndexView.Render(w, r, vd)
+}
+
+func (e *Exports) items(r *http.Request, user *models.User) ([]ExportItem, error) {
+	exports, err := e.es.ByUserID(r.Context(), user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	items := make([]ExportItem, len(exports))
+	for i, ex := range exports {
+		items[i] = ExportItem{
+			CreatedAt: ex.CreatedAt,
+			Status:    ex.Status,
+			ExpiresAt: ex.ExpiresAt,
+		}
+		if ex.Downloadable(now) {
+			items[i].DownloadURL = e.downloadURL(&ex)
+		}
+	}
+
+	return items, nil
+}
+
+// Create is used to start a new export of the user data.
+// It is built in the background, the user is emailed a
+// download link when it is ready. Admins impersonating the
+// user can't.
+//
+// POST /account/export
+func (e *Exports) Create(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var vd views.Data
+	if impersonating(r) {
+		vd.SetAlert(errImpersonating)
+		vd.Yield, _ = e.items(r, user)
+		e.IndexView.Render(w, r, vd)
+		return
+	}
+
+	exports, err := e.es.ByUserID(r.Context(), user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		e.IndexView.Render(w, r, vd)
+		return
+	}
+
+	for _, ex := range exports {
+		if ex.Status == models.ExportPending {
+			vd.SetAlert(errExportPending)
+			vd.Yield, _ = e.items(r, user)
+			e.IndexView.Render(w, r, vd)
+			return
+		}
+	}
+
+	export := models.Export{UserID: user.ID}
+	if err := e.es.Create(r.Context(), &export); err != nil {
+		vd.SetAlert(err)
+		e.IndexView.Render(w, r, vd)
+		return
+	}
+
+	name, addr := user.Name, user.Email
+	// The export outlives the request, it runs with the
+	// context of the job. Past the timeout it is failed, like
+	// the exports left pending by a process which died.
+	e.jr.Go("export user data", func(ctx stdcontext.Context) error {
+		ctx, cancel := stdcontext.WithTimeout(ctx, utils.GetExportTimeout())
+		defer cancel()
+		if err := e.es.Run(ctx, &export); err != nil {
+			return err
+		}
+		return e.notify(name, addr, &export)
+	})
+
+	path := Reverse(ExportsIndexURL, "/account/export", e.r)
+	http.Redirect(w, r, path, http.StatusFound)
+}
+
+func (e *Exports) notify(name, addr string, export *models.Export) error {
+	link := utils.GetBaseURL() + e.downloadURL(export)
+	body := fmt.Sprintf("Hi %s,\n\nThe export of your LensLocked.com data is ready. "+
+		"Log in and download it here:\n\n%s\n\nThe link expires on %s.\n",
+		name, link, export.ExpiresAt.Format("January 2, 2006 at 15:04 MST"))
+
+	return e.m.Send(addr, "Your LensLocked.com data export is ready", body)
+}
+
+func (e *Exports) downloadURL(export *models.Export) string {
+	id := strconv.Itoa(int(export.ID))
+	return Reverse(ExportDownloadURL, "/account/export", e.r, "id", id)
+}
+
+// Download is used to download the archive of a ready export
+// of the user, until it expires. Admins impersonating the
+// user can't.
+//
+// GET /account/export/:id/download
+func (e *Exports) Download(w http.ResponseWriter, r *http.Request) {
+	if impersonating(r) {
+		http.Error(w, errImpersonating.Public(), http.StatusForbidden)
+		return
+	}
+
+	user := context.User(r.Context())
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	export, err := e.es.ByID(r.Context(), uint(id))
+	if err != nil || !policy.Can(user, policy.Download, export) || !export.Downloadable(time.Now()) {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	f, err := e.es.Open(r.Context(), export)
+	if err != nil {
+		e.l.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	filename := fmt.Sprintf("lenslocked-%s.zip", export.CreatedAt.Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if _, err := io.Copy(w, f); err != nil {
+		e.l.Println(err)
+	}
+}
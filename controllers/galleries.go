@@ -6,9 +6,12 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"soramon0/webapp/context"
 	"soramon0/webapp/models"
+	"soramon0/webapp/policy"
+	"soramon0/webapp/utils"
 	"soramon0/webapp/views"
 
 	"github.com/gorilla/mux"
@@ -18,6 +21,9 @@ const (
 	GalleryShowURL    = "gallery_show"
 	GalleryEditURL    = "gallery_edit"
 	GalleriesIndexURL = "gallery_index"
+	GalleryCreateURL  = "gallery_create"
+	GalleryTrashURL   = "gallery_trash"
+	ImageUploadURL    = "gallery_image_upload"
 
 	maxMultipartMem = 1 << 20 // 1 megabyte
 )
@@ -26,28 +32,32 @@ const (
 // This function will panic if the templates are not
 // parsed correctly, and should only be used during
 // initial setup.
-func NewGalleries(gs models.GalleryService, is models.ImageService, r *mux.Router, l *log.Logger) *Galleries {
+func NewGalleries(gs models.GalleryService, is models.ImageService, t models.Transactor, r *mux.Router, l *log.Logger) *Galleries {
 	return &Galleries{
 		gs:        gs,
 		is:        is,
+		t:         t,
 		r:         r,
 		l:         l,
 		IndexView: views.NewView("bootstrap", "galleries/index"),
 		NewView:   views.NewView("bootstrap", "galleries/new"),
 		ShowView:  views.NewView("bootstrap", "galleries/show"),
 		EditView:  views.NewView("bootstrap", "galleries/edit"),
+		TrashView: views.NewView("bootstrap", "galleries/trash"),
 	}
 }
 
 type Galleries struct {
 	gs        models.GalleryService
 	is        models.ImageService
+	t         models.Transactor
 	r         *mux.Router
 	l         *log.Logger
 	IndexView *views.View
 	NewView   *views.View
 	ShowView  *views.View
 	EditView  *views.View
+	TrashView *views.View
 }
 
 type CreateGalleryForm struct {
@@ -73,7 +83,7 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 		UserID: u.ID,
 	}
 
-	if err := g.gs.Create(&gallery); err != nil {
+	if err := g.gs.As(actor(r)).Create(r.Context(), &gallery); err != nil {
 		vd.SetAlert(err)
 		g.NewView.Render(w, r, vd)
 		return
@@ -88,7 +98,7 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 // GET /galleries
 func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
 	user := context.User(r.Context())
-	galleries, err := g.gs.ByUserID(user.ID)
+	galleries, err := g.gs.ByUserID(r.Context(), user.ID)
 	if err != nil {
 		g.l.Println(err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
@@ -125,7 +135,7 @@ func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
 	var vd views.Data
 	vd.Yield = gallery
 	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
+	if !policy.Can(user, policy.Update, gallery) {
 		vd.SetAlert(models.ErrNotFound)
 		g.ShowView.Render(w, r, vd)
 		return
@@ -150,7 +160,7 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 	var vd views.Data
 	vd.Yield = gallery
 	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
+	if !policy.Can(user, policy.Update, gallery) {
 		vd.SetAlert(models.ErrNotFound)
 		g.EditView.Render(w, r, vd)
 		return
@@ -164,7 +174,7 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	gallery.Title = form.Title
-	if err := g.gs.Update(gallery); err != nil {
+	if err := g.gs.As(actor(r)).Update(r.Context(), gallery); err != nil {
 		vd.SetAlert(err)
 		g.EditView.Render(w, r, vd)
 		return
@@ -189,7 +199,7 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 	var vd views.Data
 	vd.Yield = gallery
 	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
+	if !policy.Can(user, policy.Update, gallery) {
 		vd.SetAlert(models.ErrNotFound)
 		g.EditView.Render(w, r, vd)
 		return
@@ -209,6 +219,7 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	is := g.is.As(actor(r))
 	files := r.MultipartForm.File["images"]
 	for _, f := range files {
 		file, err := f.Open()
@@ -218,7 +229,7 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err = g.is.Create(gallery.ID, file, f.Filename); err != nil {
+		if err = is.Create(r.Context(), gallery.ID, file, f.Filename); err != nil {
 			vd.SetAlert(err)
 			g.EditView.Render(w, r, vd)
 			return
@@ -241,7 +252,7 @@ func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 	var vd views.Data
 	vd.Yield = gallery
 	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
+	if !policy.Can(user, policy.Update, gallery) {
 		vd.SetAlert(models.ErrNotFound)
 		g.EditView.Render(w, r, vd)
 		return
@@ -253,7 +264,7 @@ func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 		GalleryID: gallery.ID,
 	}
 
-	if err = g.is.Delete(&i); err != nil {
+	if err = g.is.As(actor(r)).Delete(r.Context(), &i); err != nil {
 		vd.SetAlert(models.ErrNotFound)
 		g.EditView.Render(w, r, vd)
 		return
@@ -263,7 +274,8 @@ func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, path, http.StatusFound)
 }
 
-// UpdateDelete is used to delete a gallery.
+// Delete is used to move a gallery to the trash along with
+// its images.
 //
 // POST /galleries/:id/delete
 func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
@@ -275,13 +287,13 @@ func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
 	var vd views.Data
 	vd.Yield = gallery
 	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
+	if !policy.Can(user, policy.Delete, gallery) {
 		vd.SetAlert(models.ErrNotFound)
 		g.EditView.Render(w, r, vd)
 		return
 	}
 
-	if err := g.gs.Delete(gallery.ID); err != nil {
+	if err := deleteGallery(r, g.t, gallery.ID); err != nil {
 		vd.SetAlert(err)
 		g.EditView.Render(w, r, vd)
 		return
@@ -291,6 +303,104 @@ func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, path, http.StatusMovedPermanently)
 }
 
+// deleteGallery moves the gallery to the trash. If its images
+// can't be moved, the gallery is not deleted.
+func deleteGallery(r *http.Request, t models.Transactor, id uint) error {
+	return t.WithTx(r.Context(), func(tx *models.Tx) error {
+		if err := tx.Gallery.As(actor(r)).Delete(r.Context(), id); err != nil {
+			return err
+		}
+		return tx.Image.As(actor(r)).Trash(r.Context(), id)
+	})
+}
+
+// TrashItem is a gallery as listed on the recently deleted page.
+type TrashItem struct {
+	ID         uint
+	Title      string
+	DeletedAt  time.Time
+	PurgeAfter time.Time
+}
+
+// Trash is used to list the recently deleted galleries of the
+// user, they can be restored until they are purged.
+//
+// GET /galleries/trash
+func (g *Galleries) Trash(w http.ResponseWriter, r *http.Request) {
+	items, err := g.trashItems(r)
+	if err != nil {
+		g.l.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	vd := views.Data{Yield: items}
+	g.TrashView.Render(w, r, vd)
+}
+
+func (g *Galleries) trashItems(r *http.Request) ([]TrashItem, error) {
+	user := context.User(r.Context())
+	galleries, err := g.gs.DeletedByUserID(r.Context(), user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]TrashItem, len(galleries))
+	for i, gallery := range galleries {
+		items[i] = TrashItem{
+			ID:         gallery.ID,
+			Title:      gallery.Title,
+			DeletedAt:  gallery.DeletedAt.Time,
+			PurgeAfter: gallery.DeletedAt.Time.Add(utils.GetGalleryTrashRetention()),
+		}
+	}
+
+	return items, nil
+}
+
+// Restore is used to take a gallery out of the trash along
+// with its images.
+//
+// POST /galleries/:id/restore
+func (g *Galleries) Restore(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	vd.Yield, _ = g.trashItems(r)
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		vd.SetAlert(models.ErrNotFound)
+		g.TrashView.Render(w, r, vd)
+		return
+	}
+
+	gallery, err := g.gs.DeletedByID(r.Context(), uint(id))
+	if err != nil {
+		vd.SetAlert(err)
+		g.TrashView.Render(w, r, vd)
+		return
+	}
+
+	if !policy.Can(context.User(r.Context()), policy.Delete, gallery) {
+		vd.SetAlert(models.ErrNotFound)
+		g.TrashView.Render(w, r, vd)
+		return
+	}
+
+	err = g.t.WithTx(r.Context(), func(tx *models.Tx) error {
+		if err := tx.Gallery.As(actor(r)).Restore(r.Context(), gallery.ID); err != nil {
+			return err
+		}
+		return tx.Image.As(actor(r)).Restore(r.Context(), gallery.ID)
+	})
+	if err != nil {
+		vd.SetAlert(err)
+		g.TrashView.Render(w, r, vd)
+		return
+	}
+
+	path := Reverse(GalleryEditURL, "/galleries", g.r, "id", strconv.Itoa(int(gallery.ID)))
+	http.Redirect(w, r, path, http.StatusFound)
+}
+
 func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
 	var vd views.Data
 	vars := mux.Vars(r)
@@ -302,7 +412,7 @@ func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models
 		return nil, err
 	}
 
-	gallery, err := g.gs.ByID(uint(id))
+	gallery, err := g.gs.ByID(r.Context(), uint(id))
 	if err != nil {
 		g.l.Println("Error: fetching gallery", err)
 		if err == models.ErrNotFound {
@@ -316,7 +426,7 @@ func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models
 		return nil, err
 	}
 
-	images, _ := g.is.ByGalleryID(gallery.ID)
+	images, _ := g.is.ByGalleryID(r.Context(), gallery.ID)
 	gallery.Images = images
 
 	return gallery, nil
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	stdcontext "context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+	"soramon0/webapp/models"
+	"soramon0/webapp/oidc"
+	"soramon0/webapp/ratelimit"
+	"soramon0/webapp/utils"
+	"soramon0/webapp/webauthn"
+
+	"github.com/gorilla/mux"
+	"github.com/nicholasjackson/env"
+)
+
+// testingUsers returns a Users controller backed by an in
+// memory SQLite database, and the user being impersonated.
+func testingUsers(t *testing.T) (*Users, *models.Services, *models.User) {
+	utils.Must(env.Parse())
+
+	// The views are parsed relative to the repository root.
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	db, err := lib.OpenDB(lib.DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := models.NewServicesWithDB(db)
+	t.Cleanup(func() { s.Close() })
+	if err := s.DestructiveReset(); err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{Name: "Sam Lee", Email: "sam@test.com", Password: "kq7!Vd2#pLm9"}
+	if err := s.User.Create(stdcontext.Background(), &user); err != nil {
+		t.Fatal(err)
+	}
+
+	l := log.New(io.Discard, "", 0)
+	return NewUsers(s.User, s, ratelimit.NewMemoryStore(), mux.NewRouter(), l), s, &user
+}
+
+// impersonatedRequest returns a request of user made by an
+// admin impersonating them.
+func impersonatedRequest(method, target string, user *models.User) *http.Request {
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader("code=123456")
+	}
+	r := httptest.NewRequest(method, target, body)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	admin := &models.User{Name: "Admin", Role: models.RoleAdmin}
+	admin.ID = user.ID + 1
+	ctx := context.WithUser(r.Context(), user)
+	ctx = context.WithImpersonator(ctx, admin)
+	return r.WithContext(ctx)
+}
+
+func TestImpersonatingTwoFactor(t *testing.T) {
+	uc, s, user := testingUsers(t)
+	handlers := map[string]http.HandlerFunc{
+		"GET /account/2fa":          uc.TwoFactorSetup,
+		"POST /account/2fa/enable":  uc.EnableTwoFactor,
+		"POST /account/2fa/disable": uc.DisableTwoFactor,
+	}
+
+	alert := errImpersonating.Public()
+	for name, h := range handlers {
+		method, target, _ := strings.Cut(name, " ")
+		w := httptest.NewRecorder()
+		h(w, impersonatedRequest(method, target, user))
+		if !strings.Contains(w.Body.String(), alert) {
+			t.Errorf("%s: expected the impersonation alert", name)
+		}
+	}
+
+	got, err := s.User.ByID(stdcontext.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TOTPSecret != "" || got.TOTPEnabled {
+		t.Error("Expected two-factor authentication to be left as is")
+	}
+}
+
+func TestImpersonatingPasskeys(t *testing.T) {
+	uc, s, user := testingUsers(t)
+	rp := webauthn.New(webauthn.Config{RPID: "localhost", RPName: TOTPIssuer, Origin: "http://localhost"})
+	p := NewPasskeys(s.Credential, uc, rp, webauthn.NewMemoryStore(), mux.NewRouter(), uc.l)
+
+	handlers := map[string]http.HandlerFunc{
+		"/webauthn/register/begin":  p.BeginRegistration,
+		"/webauthn/register/finish": p.FinishRegistration,
+	}
+	for target, h := range handlers {
+		w := httptest.NewRecorder()
+		h(w, impersonatedRequest(http.MethodPost, target, user))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status %d. Recieved %d", target, http.StatusForbidden, w.Code)
+		}
+		if len(w.Result().Cookies()) != 0 {
+			t.Errorf("%s: expected no passkey session to start", target)
+		}
+	}
+
+	c := models.Credential{UserID: user.ID, CredentialID: "credential", PublicKey: []byte("key")}
+	if err := s.Credential.Create(stdcontext.Background(), &c); err != nil {
+		t.Fatal(err)
+	}
+	target := fmt.Sprintf("/account/passkeys/%d/delete", c.ID)
+	r := mux.SetURLVars(impersonatedRequest(http.MethodPost, target, user), map[string]string{"id": fmt.Sprint(c.ID)})
+	w := httptest.NewRecorder()
+	p.Delete(w, r)
+	if !strings.Contains(w.Body.String(), errImpersonating.Public()) {
+		t.Errorf("%s: expected the impersonation alert", target)
+	}
+	if _, err := s.Credential.ByID(stdcontext.Background(), c.ID); err != nil {
+		t.Errorf("Expected the passkey to be kept. Recieved %v", err)
+	}
+}
+
+func TestImpersonatingExports(t *testing.T) {
+	uc, s, user := testingUsers(t)
+	ctx := stdcontext.Background()
+	e := NewExports(s.Export, nil, nil, mux.NewRouter(), uc.l)
+
+	w := httptest.NewRecorder()
+	e.Create(w, impersonatedRequest(http.MethodPost, "/account/export", user))
+	if !strings.Contains(w.Body.String(), errImpersonating.Public()) {
+		t.Error("Create: expected the impersonation alert")
+	}
+	exports, err := s.Export.ByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exports) != 0 {
+		t.Errorf("Expected no export to be started. Recieved %d", len(exports))
+	}
+
+	export := models.Export{UserID: user.ID, Status: models.ExportReady, Filename: "export.zip"}
+	if err := s.Export.Create(ctx, &export); err != nil {
+		t.Fatal(err)
+	}
+	target := fmt.Sprintf("/account/export/%d/download", export.ID)
+	r := mux.SetURLVars(impersonatedRequest(http.MethodGet, target, user), map[string]string{"id": fmt.Sprint(export.ID)})
+	w = httptest.NewRecorder()
+	e.Download(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Download: expected status %d. Recieved %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestImpersonatingLinkIdentity(t *testing.T) {
+	uc, s, user := testingUsers(t)
+	op := oidc.NewProvider(oidc.Config{Name: "Test", Issuer: "https://issuer.test"})
+	o := NewOIDC(op, s.Identity, uc, mux.NewRouter(), uc.l)
+
+	r := impersonatedRequest(http.MethodGet, "/auth/oidc/callback", user)
+	claims := &oidc.Claims{Subject: "subject", Email: user.Email, EmailVerified: true}
+	if _, err := o.linkIdentity(r, claims); err != errImpersonating {
+		t.Fatalf("linkIdentity err = %v, want errImpersonating", err)
+	}
+
+	_, err := s.Identity.ByProviderSubject(stdcontext.Background(), op.Issuer(), claims.Subject)
+	if err != models.ErrNotFound {
+		t.Errorf("Expected no identity to be linked. Recieved %v", err)
+	}
+}
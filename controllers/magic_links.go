@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"soramon0/webapp/email"
+	"soramon0/webapp/models"
+	"soramon0/webapp/utils"
+	"soramon0/webapp/views"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	MagicLinkURL = "magic_link"
+
+	magicLinkSent = "If an account exists for this email address, we sent it a login link. It expires in 15 minutes."
+)
+
+// NewMagicLinks is used to create a new MagicLinks controller
+// signing users in through the Users controller.
+// This function will panic if the templates are not
+// parsed correctly, and should only be used during
+// initial setup.
+func NewMagicLinks(m email.Mailer, uc *Users, r *mux.Router, l *log.Logger) *MagicLinks {
+	return &MagicLinks{
+		NewView:     views.NewView("bootstrap", "users/magic_link"),
+		ConfirmView: views.NewView("bootstrap", "users/magic_link_confirm"),
+		m:           m,
+		uc:          uc,
+		r:           r,
+		l:           l,
+	}
+}
+
+type MagicLinks struct {
+	NewView     *views.View
+	ConfirmView *views.View
+	m           email.Mailer
+	uc          *Users
+	r           *mux.Router
+	l           *log.Logger
+}
+
+type MagicLinkForm struct {
+	Email string `schema:"email,required"`
+}
+
+// Create is used to email a login link to the user. The same
+// message is shown whether the account exists or not, so the
+// form can't be used to find out who has an account.
+//
+// POST /login/magic
+func (ml *MagicLinks) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form MagicLinkForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		ml.NewView.Render(w, r, vd)
+		return
+	}
+
+	if err := ml.uc.throttleLogin(r, form.Email); err != nil {
+		vd.SetAlert(err)
+		ml.NewView.Render(w, r, vd)
+		return
+	}
+
+	if err := ml.send(r, form.Email); err != nil && err != models.ErrNotFound {
+		vd.SetAlert(err)
+		ml.NewView.Render(w, r, vd)
+		return
+	}
+
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLevelSucess,
+		Message: magicLinkSent,
+	}
+	ml.NewView.Render(w, r, vd)
+}
+
+func (ml *MagicLinks) send(r *http.Request, addr string) error {
+	user, err := ml.uc.us.ByEmail(r.Context(), addr)
+	if err != nil {
+		return err
+	}
+
+	token, err := ml.uc.us.IssueMagicLink(r.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	link := utils.GetBaseURL() + "/login/magic/callback?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nClick the link below to log in to LensLocked.com:\n\n%s\n\n"+
+		"The link expires in 15 minutes and can only be used once. "+
+		"If you did not ask for it, you can ignore this email.\n", user.Name, link)
+
+	return ml.m.Send(user.Email, "Your LensLocked.com login link", body)
+}
+
+// Confirm is used to render the page the emailed link points
+// to. Signing in takes a POST, so email scanners following the
+// link don't use the token up.
+//
+// GET /login/magic/callback
+func (ml *MagicLinks) Confirm(w http.ResponseWriter, r *http.Request) {
+	vd := views.Data{Yield: r.URL.Query().Get("token")}
+	ml.ConfirmView.Render(w, r, vd)
+}
+
+type MagicLinkCallbackForm struct {
+	Token string `schema:"token,required"`
+}
+
+// Callback is used to sign the user in with a magic link token.
+//
+// POST /login/magic/callback
+func (ml *MagicLinks) Callback(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form MagicLinkCallbackForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(models.ErrMagicLinkInvalid)
+		ml.NewView.Render(w, r, vd)
+		return
+	}
+
+	user, err := ml.uc.us.ConsumeMagicLink(r.Context(), form.Token)
+	if err != nil {
+		vd.SetAlert(err)
+		ml.NewView.Render(w, r, vd)
+		return
+	}
+
+	if user.TOTPEnabled {
+		ml.uc.beginTwoFactor(w, user)
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+
+	if err := ml.uc.signIn(w, r, user); err != nil {
+		vd.SetAlert(err)
+		ml.NewView.Render(w, r, vd)
+		return
+	}
+
+	path := Reverse(GalleriesIndexURL, "/", ml.r)
+	http.Redirect(w, r, path, http.StatusFound)
+}
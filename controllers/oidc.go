@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+	"soramon0/webapp/models"
+	"soramon0/webapp/oidc"
+	"soramon0/webapp/views"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	oidcSessionCookie = "oidc_session"
+	oidcSessionPath   = "/auth/oidc"
+	oidcSessionTTL    = 10 * time.Minute
+	// oidcSessionPurpose is signed along the session so the
+	// cookie can't be mistaken for another signed value.
+	oidcSessionPurpose = "oidc"
+	oidcStateBytes     = 16
+)
+
+// NewOIDC is used to create a new OIDC controller signing
+// users in with an OpenID Connect provider. When the provider
+// is configured, it adds its button to the login page of uc.
+func NewOIDC(p *oidc.Provider, ids models.IdentityService, uc *Users, r *mux.Router, l *log.Logger) *OIDC {
+	if p.Enabled() {
+		uc.ssoName = p.Name()
+	}
+
+	return &OIDC{
+		p:   p,
+		ids: ids,
+		uc:  uc,
+		r:   r,
+		l:   l,
+	}
+}
+
+type OIDC struct {
+	p   *oidc.Provider
+	ids models.IdentityService
+	uc  *Users
+	r   *mux.Router
+	l   *log.Logger
+}
+
+// Login redirects the user to the provider, starting the
+// authorization code flow with PKCE.
+//
+// GET /auth/oidc/login
+func (o *OIDC) Login(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	state, err := lib.Base64FromBytes(oidcStateBytes)
+	if err != nil {
+		vd.SetAlert(err)
+		o.uc.renderLogin(w, r, vd)
+		return
+	}
+	nonce, err := lib.Base64FromBytes(oidcStateBytes)
+	if err != nil {
+		vd.SetAlert(err)
+		o.uc.renderLogin(w, r, vd)
+		return
+	}
+	verifier, challenge, err := oidc.PKCE()
+	if err != nil {
+		vd.SetAlert(err)
+		o.uc.renderLogin(w, r, vd)
+		return
+	}
+
+	authURL, err := o.p.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		o.l.Println("Error: starting OIDC login", err)
+		vd.SetAlert(errSSOFailed)
+		o.uc.renderLogin(w, r, vd)
+		return
+	}
+
+	expires := time.Now().Add(oidcSessionTTL)
+	value := strings.Join([]string{
+		oidcSessionPurpose,
+		state,
+		nonce,
+		verifier,
+		strconv.FormatInt(expires.Unix(), 10),
+	}, ":")
+
+	// Lax, the callback is a top-level navigation
+	// coming from the provider.
+	c := http.Cookie{
+		Name:     oidcSessionCookie,
+		Value:    o.uc.hmac.Sign(value),
+		Path:     oidcSessionPath,
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &c)
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback is where the provider sends the user back. It
+// verifies the ID token, links the identity to a user and
+// signs them in.
+//
+// GET /auth/oidc/callback
+func (o *OIDC) Callback(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	nonce, verifier, err := o.endSession(w, r)
+	if err != nil {
+		vd.SetAlert(errSSOExpired)
+		o.uc.renderLogin(w, r, vd)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("error") != "" {
+		o.l.Println("Error: OIDC provider returned", q.Get("error"), q.Get("error_description"))
+		vd.SetAlert(errSSOFailed)
+		o.uc.renderLogin(w, r, vd)
+		return
+	}
+
+	claims, err := o.p.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		o.l.Println("Error: OIDC code exchange", err)
+		vd.SetAlert(errSSOFailed)
+		o.uc.renderLogin(w, r, vd)
+		return
+	}
+
+	user, err := o.linkIdentity(r, claims)
+	if err != nil {
+		vd.SetAlert(err)
+		o.uc.renderLogin(w, r, vd)
+		return
+	}
+
+	if user.TOTPEnabled {
+		o.uc.beginTwoFactor(w, user)
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+
+	if err := o.uc.signIn(w, r, user); err != nil {
+		vd.SetAlert(err)
+		o.uc.renderLogin(w, r, vd)
+		return
+	}
+
+	path := Reverse(GalleriesIndexURL, "/", o.r)
+	http.Redirect(w, r, path, http.StatusFound)
+}
+
+// linkIdentity returns the user linked to the identity in
+// claims. Unknown identities are linked to the signed in
+// user, then to the user with the same verified email, and
+// otherwise to a new account without password. An admin
+// impersonating a user can't link an identity to them.
+func (o *OIDC) linkIdentity(r *http.Request, claims *oidc.Claims) (*models.User, error) {
+	identity, err := o.ids.ByProviderSubject(r.Context(), o.p.Issuer(), claims.Subject)
+	if err == nil {
+		return o.uc.us.ByID(r.Context(), identity.UserID)
+	}
+	if err != models.ErrNotFound {
+		return nil, err
+	}
+
+	user := context.User(r.Context())
+	if user != nil && impersonating(r) {
+		return nil, errImpersonating
+	}
+	if user == nil && claims.Email != "" {
+		existing, err := o.uc.us.ByEmail(r.Context(), claims.Email)
+		switch {
+		case err == nil && claims.EmailVerified:
+			user = existing
+		case err == nil:
+			// Linking on an email the provider did not verify
+			// would let anybody take over the account.
+			return nil, errSSOEmailTaken
+		case err != models.ErrNotFound:
+			return nil, err
+		}
+	}
+
+	if user == nil {
+		user = &models.User{
+			Name:       claims.Name,
+			Email:      claims.Email,
+			NoPassword: true,
+		}
+		if err := o.uc.us.As(actor(r)).Create(r.Context(), user); err != nil {
+			return nil, err
+		}
+	}
+
+	identity = &models.Identity{
+		UserID:   user.ID,
+		Provider: o.p.Issuer(),
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := o.ids.Create(r.Context(), identity); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// endSession clears the session cookie and returns its nonce
+// and PKCE verifier if the state matches the callback.
+func (o *OIDC) endSession(w http.ResponseWriter, r *http.Request) (nonce, verifier string, err error) {
+	cookie, err := r.Cookie(oidcSessionCookie)
+	if err != nil {
+		return "", "", err
+	}
+	clearCookie(w, oidcSessionCookie, oidcSessionPath)
+
+	value, err := o.uc.hmac.Verify(cookie.Value)
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 5 || parts[0] != oidcSessionPurpose {
+		return "", "", lib.ErrInvalidSignature
+	}
+	if parts[1] != r.URL.Query().Get("state") {
+		return "", "", lib.ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		return "", "", err
+	}
+	if time.Now().Unix() > expires {
+		return "", "", errSSOExpired
+	}
+
+	return parts[2], parts[3], nil
+}
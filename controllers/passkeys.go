@@ -0,0 +1,364 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+	"soramon0/webapp/models"
+	"soramon0/webapp/policy"
+	"soramon0/webapp/ratelimit"
+	"soramon0/webapp/views"
+	"soramon0/webapp/webauthn"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	PasskeysIndexURL = "passkeys_index"
+
+	webauthnSessionCookie = "webauthn_session"
+	webauthnSessionPath   = "/webauthn"
+
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+)
+
+// NewPasskeys is used to create a new Passkeys controller.
+// It signs users in through the Users controller, and keeps
+// the ceremonies in progress in ss.
+// This function will panic if the templates are not
+// parsed correctly, and should only be used during
+// initial setup.
+func NewPasskeys(cs models.CredentialService, uc *Users, rp *webauthn.RelyingParty, ss webauthn.SessionStore, r *mux.Router, l *log.Logger) *Passkeys {
+	return &Passkeys{
+		IndexView: views.NewView("bootstrap", "passkeys/index"),
+		cs:        cs,
+		uc:        uc,
+		rp:        rp,
+		ss:        ss,
+		r:         r,
+		l:         l,
+	}
+}
+
+type Passkeys struct {
+	IndexView *views.View
+	cs        models.CredentialService
+	uc        *Users
+	rp        *webauthn.RelyingParty
+	ss        webauthn.SessionStore
+	r         *mux.Router
+	l         *log.Logger
+}
+
+// Index is used to list the passkeys of the user.
+//
+// GET /account/passkeys
+func (p *Passkeys) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	credentials, err := p.cs.ByUserID(r.Context(), user.ID)
+	if err != nil {
+		p.l.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = credentials
+	p.IndexView.Render(w, r, vd)
+}
+
+// Delete is used to remove a passkey of the user. It is not
+// allowed while impersonating the user.
+//
+// POST /account/passkeys/:id/delete
+func (p *Passkeys) Delete(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var vd views.Data
+	if impersonating(r) {
+		vd.SetAlert(errImpersonating)
+		p.IndexView.Render(w, r, vd)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		vd.SetAlert(models.ErrNotFound)
+		p.IndexView.Render(w, r, vd)
+		return
+	}
+
+	c, err := p.cs.ByID(r.Context(), uint(id))
+	if err != nil || !policy.Can(user, policy.Delete, c) {
+		vd.SetAlert(models.ErrNotFound)
+		p.IndexView.Render(w, r, vd)
+		return
+	}
+
+	if err := p.cs.Delete(r.Context(), c.ID); err != nil {
+		vd.SetAlert(err)
+		p.IndexView.Render(w, r, vd)
+		return
+	}
+
+	path := Reverse(PasskeysIndexURL, "/", p.r)
+	http.Redirect(w, r, path, http.StatusFound)
+}
+
+// BeginRegistration starts the registration of a new passkey
+// for the user and returns the options for
+// navigator.credentials.create. It is not allowed while
+// impersonating the user.
+//
+// POST /webauthn/register/begin
+func (p *Passkeys) BeginRegistration(w http.ResponseWriter, r *http.Request) {
+	if impersonating(r) {
+		renderJSONError(w, http.StatusForbidden, errImpersonating)
+		return
+	}
+
+	user := context.User(r.Context())
+	credentials, err := p.cs.ByUserID(r.Context(), user.ID)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	exclude := make([][]byte, 0, len(credentials))
+	for _, c := range credentials {
+		id, err := base64.RawURLEncoding.DecodeString(c.CredentialID)
+		if err != nil {
+			continue
+		}
+		exclude = append(exclude, id)
+	}
+
+	challenge, err := p.beginSession(w, ceremonyRegister, user.ID)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	wu := webauthn.User{
+		ID:          webauthnUserID(user.ID),
+		Name:        user.Email,
+		DisplayName: user.Name,
+	}
+	renderJSON(w, http.StatusOK, p.rp.CreationOptions(wu, challenge, exclude))
+}
+
+type FinishRegistrationForm struct {
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
+// FinishRegistration verifies the new passkey and stores it.
+//
+// POST /webauthn/register/finish
+func (p *Passkeys) FinishRegistration(w http.ResponseWriter, r *http.Request) {
+	if impersonating(r) {
+		renderJSONError(w, http.StatusForbidden, errImpersonating)
+		return
+	}
+
+	user := context.User(r.Context())
+	challenge, err := p.endSession(w, r, ceremonyRegister, user.ID)
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, errPasskeyExpired)
+		return
+	}
+
+	var form FinishRegistrationForm
+	if err := parseJSON(w, r, &form); err != nil {
+		renderJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cred, err := p.rp.VerifyRegistration(challenge, form.Credential)
+	if err != nil {
+		p.l.Println("Error: verifying passkey registration", err)
+		renderJSONError(w, http.StatusBadRequest, errPasskeyInvalid)
+		return
+	}
+
+	c := models.Credential{
+		UserID:       user.ID,
+		Name:         strings.TrimSpace(form.Name),
+		CredentialID: base64.RawURLEncoding.EncodeToString(cred.ID),
+		PublicKey:    cred.PublicKey,
+		SignCount:    cred.SignCount,
+	}
+	if err := p.cs.Create(r.Context(), &c); err != nil {
+		renderJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	renderJSON(w, http.StatusOK, map[string]string{
+		"redirect": Reverse(PasskeysIndexURL, "/", p.r),
+	})
+}
+
+// BeginLogin starts a passkey login and returns the options
+// for navigator.credentials.get. No user is needed, the
+// authenticator lets the user pick one of their passkeys.
+//
+// POST /webauthn/login/begin
+func (p *Passkeys) BeginLogin(w http.ResponseWriter, r *http.Request) {
+	challenge, err := p.beginSession(w, ceremonyLogin, 0)
+	if err != nil {
+		renderJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	renderJSON(w, http.StatusOK, p.rp.RequestOptions(challenge, nil))
+}
+
+// FinishLogin verifies the passkey assertion and signs the
+// owner of the passkey in.
+//
+// POST /webauthn/login/finish
+func (p *Passkeys) FinishLogin(w http.ResponseWriter, r *http.Request) {
+	challenge, err := p.endSession(w, r, ceremonyLogin, 0)
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, errPasskeyExpired)
+		return
+	}
+
+	res, err := p.uc.loginByIP.Allow(lib.ClientIP(r))
+	if err == nil && !res.Allowed {
+		err = ratelimit.LimitError{RetryAfter: res.RetryAfter}
+	}
+	if err != nil {
+		renderJSONError(w, http.StatusTooManyRequests, err)
+		return
+	}
+
+	var resp webauthn.AssertionResponse
+	if err := parseJSON(w, r, &resp); err != nil {
+		renderJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	c, err := p.cs.ByCredentialID(r.Context(), base64.RawURLEncoding.EncodeToString(resp.RawID))
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, errPasskeyInvalid)
+		return
+	}
+
+	wc := webauthn.Credential{
+		ID:        resp.RawID,
+		PublicKey: c.PublicKey,
+		SignCount: c.SignCount,
+	}
+	count, err := p.rp.VerifyAssertion(challenge, wc, resp)
+	if err != nil {
+		p.l.Println("Error: verifying passkey assertion", err)
+		renderJSONError(w, http.StatusBadRequest, errPasskeyInvalid)
+		return
+	}
+
+	handle := resp.Response.UserHandle
+	if len(handle) > 0 && !bytes.Equal(handle, webauthnUserID(c.UserID)) {
+		renderJSONError(w, http.StatusBadRequest, errPasskeyInvalid)
+		return
+	}
+
+	now := time.Now()
+	c.SignCount = count
+	c.LastUsedAt = &now
+	if err := p.cs.Update(r.Context(), c); err != nil {
+		renderJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	user, err := p.uc.us.ByID(r.Context(), c.UserID)
+	if err != nil {
+		renderJSONError(w, http.StatusBadRequest, errPasskeyInvalid)
+		return
+	}
+
+	if err := p.uc.signIn(w, r, user); err != nil {
+		renderJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	renderJSON(w, http.StatusOK, map[string]string{
+		"redirect": Reverse(GalleriesIndexURL, "/", p.r),
+	})
+}
+
+// beginSession generates a challenge and keeps it in the
+// session store until the ceremony finishes. The cookie only
+// holds the ID of the session.
+func (p *Passkeys) beginSession(w http.ResponseWriter, ceremony string, userID uint) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := lib.RememberToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(p.rp.Timeout())
+	err = p.ss.Put(id, webauthn.Session{
+		Ceremony:  ceremony,
+		UserID:    userID,
+		Challenge: challenge,
+		Expires:   expires,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := http.Cookie{
+		Name:     webauthnSessionCookie,
+		Value:    id,
+		Path:     webauthnSessionPath,
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	http.SetCookie(w, &c)
+
+	return challenge, nil
+}
+
+// endSession clears the session cookie and returns its
+// challenge if the session is valid for the ceremony and user.
+// The session is taken out of the store, so a challenge can
+// only be used once even if the cookie is replayed.
+func (p *Passkeys) endSession(w http.ResponseWriter, r *http.Request, ceremony string, userID uint) ([]byte, error) {
+	cookie, err := r.Cookie(webauthnSessionCookie)
+	if err != nil {
+		return nil, err
+	}
+	clearCookie(w, webauthnSessionCookie, webauthnSessionPath)
+
+	session, ok, err := p.ss.Take(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errPasskeyExpired
+	}
+	if session.Ceremony != ceremony || session.UserID != userID {
+		return nil, errPasskeyExpired
+	}
+
+	return session.Challenge, nil
+}
+
+// webauthnUserID is the user handle stored by authenticators.
+// It must not contain personal information, the ID is enough.
+func webauthnUserID(id uint) []byte {
+	return []byte(strconv.Itoa(int(id)))
+}
@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+	"soramon0/webapp/models"
+	"soramon0/webapp/views"
+
+	"rsc.io/qr"
+)
+
+const (
+	TOTPIssuer = "LensLocked.com"
+
+	TwoFactorDisableURL = "two_factor_disable"
+
+	pendingTwoFactorCookie = "pending_2fa"
+	pendingTwoFactorTTL    = 5 * time.Minute
+	// pendingTwoFactorPurpose is signed along the user ID so
+	// the cookie can't be mistaken for another signed value.
+	pendingTwoFactorPurpose = "2fa"
+)
+
+type TwoFactorForm struct {
+	Code string `schema:"code,required"`
+}
+
+// TwoFactorSetup is used to render the two-factor setup page.
+// If two-factor authentication is not enabled yet, it starts
+// a new enrollment and shows the secret and its QR code,
+// unless an admin is impersonating the user.
+//
+// GET /account/2fa
+func (u *Users) TwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var vd views.Data
+	if user.TOTPEnabled {
+		vd.Yield = TwoFactorSetupData{Enabled: true}
+		u.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+	// Enrolling replaces the pending secret of the user.
+	if impersonating(r) {
+		vd.SetAlert(errImpersonating)
+		u.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+
+	secret, err := u.us.EnrollTOTP(r.Context(), user)
+	if err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+
+	code, err := qr.Encode(lib.TOTPURL(TOTPIssuer, user.Email, secret), qr.M)
+	if err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+
+	vd.Yield = TwoFactorSetupData{
+		Secret: secret,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()),
+	}
+	u.TwoFactorSetupView.Render(w, r, vd)
+}
+
+type TwoFactorSetupData struct {
+	Enabled bool
+	Secret  string
+	QRCode  string
+}
+
+// EnableTwoFactor confirms the enrollment with a code from the
+// authenticator app and shows the backup codes. It is not
+// allowed while impersonating the user.
+//
+// POST /account/2fa/enable
+func (u *Users) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var vd views.Data
+	if impersonating(r) {
+		vd.SetAlert(errImpersonating)
+		u.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+
+	var form TwoFactorForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+
+	codes, err := u.us.EnableTOTP(r.Context(), user, form.Code)
+	if err != nil {
+		vd.SetAlert(err)
+		vd.Yield = TwoFactorSetupData{}
+		u.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+
+	vd.Yield = codes
+	u.BackupCodesView.Render(w, r, vd)
+}
+
+// DisableTwoFactor disables two-factor authentication after
+// checking a TOTP or backup code. It is not allowed while
+// impersonating the user.
+//
+// POST /account/2fa/disable
+func (u *Users) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	vd := views.Data{Yield: TwoFactorSetupData{Enabled: true}}
+	if impersonating(r) {
+		vd.SetAlert(errImpersonating)
+		u.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+
+	var form TwoFactorForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+
+	if err := u.us.DisableTOTP(r.Context(), user, form.Code); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorSetupView.Render(w, r, vd)
+		return
+	}
+
+	http.Redirect(w, r, "/account/2fa", http.StatusFound)
+}
+
+// TwoFactor is the second login step of users with two-factor
+// authentication enabled. It verifies the code for the user
+// in the pending state and signs them in.
+//
+// POST /login/2fa
+func (u *Users) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user, err := u.pendingTwoFactor(r)
+	if err != nil {
+		vd.SetAlert(errTwoFactorExpired)
+		u.renderLogin(w, r, vd)
+		return
+	}
+
+	var form TwoFactorForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+
+	if err := u.throttleLogin(r, user.Email); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+
+	if err := u.us.VerifyTOTP(r.Context(), user, form.Code); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+
+	clearCookie(w, pendingTwoFactorCookie, "/login")
+	if err := u.signIn(w, r, user); err != nil {
+		vd.SetAlert(err)
+		u.renderLogin(w, r, vd)
+		return
+	}
+
+	path := Reverse(GalleriesIndexURL, "/", u.r)
+	http.Redirect(w, r, path, http.StatusFound)
+}
+
+// beginTwoFactor puts the user in the pending two-factor state,
+// a short-lived signed cookie holding the user ID. The remember
+// token is only issued once the second factor is verified.
+func (u *Users) beginTwoFactor(w http.ResponseWriter, user *models.User) {
+	expires := time.Now().Add(pendingTwoFactorTTL)
+	value := strings.Join([]string{
+		pendingTwoFactorPurpose,
+		strconv.Itoa(int(user.ID)),
+		strconv.FormatInt(expires.Unix(), 10),
+	}, ":")
+
+	c := http.Cookie{
+		Name:     pendingTwoFactorCookie,
+		Value:    u.hmac.Sign(value),
+		Path:     "/login",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &c)
+}
+
+// pendingTwoFactor returns the user in the pending two-factor
+// state, or an error if the cookie is missing, invalid or expired.
+func (u *Users) pendingTwoFactor(r *http.Request) (*models.User, error) {
+	cookie, err := r.Cookie(pendingTwoFactorCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := u.hmac.Verify(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 || parts[0] != pendingTwoFactorPurpose {
+		return nil, lib.ErrInvalidSignature
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > expires {
+		return nil, errTwoFactorExpired
+	}
+
+	return u.us.ByID(r.Context(), uint(id))
+}
+
+func clearCookie(w http.ResponseWriter, name, path string) {
+	c := http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     path,
+		MaxAge:   -1,
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &c)
+}
@@ -3,34 +3,71 @@ package controllers
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"soramon0/webapp/lib"
 	"soramon0/webapp/models"
+	"soramon0/webapp/ratelimit"
+	"soramon0/webapp/utils"
 	"soramon0/webapp/views"
 
 	"github.com/gorilla/mux"
 )
 
+const (
+	SignupURL = "signup"
+)
+
+var (
+	// loginIPRate allows bursts of logins from the same IP,
+	// which may be shared by many users (e.g. an office).
+	loginIPRate = ratelimit.Rate{Burst: 20, Period: 10 * time.Minute}
+	// loginEmailRate limits the attempts against a single account.
+	loginEmailRate = ratelimit.Rate{Burst: 10, Period: 10 * time.Minute}
+)
+
 // New Users is used to create a new Users controller.
 // This function will panic if the templates are not
 // parsed correctly, and should only be used during
 // initial setup.
-func NewUsers(us models.UserService, r *mux.Router, l *log.Logger) *Users {
+func NewUsers(us models.UserService, t models.Transactor, ls ratelimit.Store, r *mux.Router, l *log.Logger) *Users {
+	hmac, err := lib.NewHMACFromEnv()
+	utils.Must(err)
+
 	return &Users{
-		SignupView: views.NewView("bootstrap", "users/new"),
-		LoginView:  views.NewView("bootstrap", "users/login"),
-		us:         us,
-		r:          r,
-		l:          l,
+		SignupView:         views.NewView("bootstrap", "users/new"),
+		LoginView:          views.NewView("bootstrap", "users/login"),
+		TwoFactorView:      views.NewView("bootstrap", "users/two_factor"),
+		TwoFactorSetupView: views.NewView("bootstrap", "users/two_factor_setup"),
+		BackupCodesView:    views.NewView("bootstrap", "users/backup_codes"),
+		AccountView:        views.NewView("bootstrap", "users/account"),
+		AccountDeletedView: views.NewView("bootstrap", "users/account_deleted"),
+		us:                 us,
+		t:                  t,
+		hmac:               hmac,
+		loginByIP:          ratelimit.New("login_ip", loginIPRate, ls),
+		loginByEmail:       ratelimit.New("login_email", loginEmailRate, ls),
+		r:                  r,
+		l:                  l,
 	}
 }
 
 type Users struct {
-	SignupView *views.View
-	LoginView  *views.View
-	us         models.UserService
-	r          *mux.Router
-	l          *log.Logger
+	SignupView         *views.View
+	LoginView          *views.View
+	TwoFactorView      *views.View
+	TwoFactorSetupView *views.View
+	BackupCodesView    *views.View
+	AccountView        *views.View
+	AccountDeletedView *views.View
+	us                 models.UserService
+	t                  models.Transactor
+	hmac               lib.HMAC
+	ssoName            string
+	loginByIP          *ratelimit.Limiter
+	loginByEmail       *ratelimit.Limiter
+	r                  *mux.Router
+	l                  *log.Logger
 }
 
 type SignupForm struct {
@@ -58,21 +95,43 @@ func (u *Users) Signup(w http.ResponseWriter, r *http.Request) {
 		Password: form.Password,
 	}
 
-	if err := u.us.Create(&user); err != nil {
+	// The account is only created along with its session.
+	err := u.t.WithTx(r.Context(), func(tx *models.Tx) error {
+		if err := tx.User.As(actor(r)).Create(r.Context(), &user); err != nil {
+			return err
+		}
+		return startSession(r, tx.User, &user)
+	})
+	if err != nil {
 		vd.SetAlert(err)
 		u.SignupView.Render(w, r, vd)
 		return
 	}
-
-	if err := u.signIn(w, &user); err != nil {
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return
-	}
+	setRememberCookie(w, &user)
 
 	path := Reverse(GalleriesIndexURL, "/", u.r)
 	http.Redirect(w, r, path, http.StatusFound)
 }
 
+// LoginData is the data of the login page.
+type LoginData struct {
+	// SSOName is the name of the identity provider users
+	// can sign in with, it is empty when there is none.
+	SSOName string
+}
+
+// LoginPage is used to render the login page.
+//
+// GET /login
+func (u *Users) LoginPage(w http.ResponseWriter, r *http.Request) {
+	u.renderLogin(w, r, views.Data{})
+}
+
+func (u *Users) renderLogin(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	vd.Yield = LoginData{SSOName: u.ssoName}
+	u.LoginView.Render(w, r, vd)
+}
+
 type LoginForm struct {
 	Email    string `schema:"email,required"`
 	Password string `schema:"password,required"`
@@ -87,26 +146,38 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	var form LoginForm
 	if err := parseForm(r, &form); err != nil {
 		vd.SetAlert(err)
-		u.LoginView.Render(w, r, vd)
+		u.renderLogin(w, r, vd)
+		return
+	}
+
+	if err := u.throttleLogin(r, form.Email); err != nil {
+		vd.SetAlert(err)
+		u.renderLogin(w, r, vd)
 		return
 	}
 
-	user, err := u.us.Authenticate(form.Email, form.Password)
+	user, err := u.us.As(actor(r)).Authenticate(r.Context(), form.Email, form.Password)
 	if err != nil {
 		if err == models.ErrNotFound {
 			vd.AlertError("Invalid email address")
-			u.LoginView.Render(w, r, vd)
+			u.renderLogin(w, r, vd)
 			return
 		}
 
 		vd.SetAlert(err)
-		u.LoginView.Render(w, r, vd)
+		u.renderLogin(w, r, vd)
+		return
+	}
+
+	if user.TOTPEnabled {
+		u.beginTwoFactor(w, user)
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
 		return
 	}
 
-	if err = u.signIn(w, user); err != nil {
+	if err = u.signIn(w, r, user); err != nil {
 		vd.SetAlert(err)
-		u.LoginView.Render(w, r, vd)
+		u.renderLogin(w, r, vd)
 		return
 	}
 
@@ -114,25 +185,80 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, path, http.StatusFound)
 }
 
-func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
-	if user.Remember == "" {
-		token, err := lib.RememberToken()
+// throttleLogin takes a token from the login limiters for both
+// the client IP and the normalized email. It returns a
+// ratelimit.LimitError if either of them is exhausted.
+func (u *Users) throttleLogin(r *http.Request, email string) error {
+	keys := []struct {
+		l   *ratelimit.Limiter
+		key string
+	}{
+		{u.loginByIP, lib.ClientIP(r)},
+		{u.loginByEmail, models.NormalizeEmail(email)},
+	}
+
+	for _, k := range keys {
+		res, err := k.l.Allow(k.key)
 		if err != nil {
 			return err
 		}
-		user.Remember = token
-		err = u.us.Update(user)
-		if err != nil {
-			return err
+		if !res.Allowed {
+			u.l.Printf("login throttled by %s for %q\n", k.l.Name(), k.key)
+			return ratelimit.LimitError{RetryAfter: res.RetryAfter}
 		}
 	}
 
+	return nil
+}
+
+// signIn sets the remember token cookie of user and records
+// the login in the audit log. Signing in cancels the pending
+// deletion of the account. Disabled users get
+// ErrAccountDisabled, whichever way they signed in.
+func (u *Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	if err := startSession(r, u.us, user); err != nil {
+		return err
+	}
+
+	// An audit log failure does not block the login, the user
+	// proved who they are and the session is already stored.
+	if err := u.us.As(actor(r)).RecordLogin(r.Context(), user); err != nil {
+		u.l.Println("Error: recording login", err)
+	}
+
+	setRememberCookie(w, user)
+	return nil
+}
+
+// startSession gives user a remember token if they have none,
+// and cancels the pending deletion of the account, storing
+// them in us.
+func startSession(r *http.Request, us models.UserService, user *models.User) error {
+	if user.IsDisabled() {
+		return models.ErrAccountDisabled
+	}
+	if user.Remember != "" {
+		return nil
+	}
+
+	token, err := lib.RememberToken()
+	if err != nil {
+		return err
+	}
+	user.Remember = token
+	user.DeleteAfter = nil
+
+	return us.Update(r.Context(), user)
+}
+
+func setRememberCookie(w http.ResponseWriter, user *models.User) {
+	// The path is set explicitly, users sign in from several
+	// pages and the cookie must be sent with every request.
 	c := http.Cookie{
 		Name:     "remember_token",
 		Value:    user.Remember,
+		Path:     "/",
 		HttpOnly: true,
 	}
 	http.SetCookie(w, &c)
-
-	return nil
 }
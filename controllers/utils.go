@@ -1,9 +1,15 @@
 package controllers
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+	"soramon0/webapp/models"
+	"soramon0/webapp/views"
+
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
 )
@@ -21,6 +27,39 @@ func parseForm(r *http.Request, dst interface{}) error {
 	return nil
 }
 
+// maxJSONBody is the maximum size of the JSON bodies we accept.
+const maxJSONBody = 64 << 10 // 64 kilobytes
+
+func parseJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBody)
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		return parseError("invalid request body")
+	}
+
+	return nil
+}
+
+func renderJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// renderJSONError renders the public message of err, or a
+// generic message if it is not a views.PublicError.
+func renderJSONError(w http.ResponseWriter, status int, err error) {
+	msg := views.AlertMsgGeneric
+	if pErr, ok := err.(views.PublicError); ok {
+		msg = pErr.Public()
+	} else {
+		fmt.Println(err)
+	}
+
+	renderJSON(w, status, map[string]string{"error": msg})
+}
+
 func Reverse(path, fallback string, r *mux.Router, pathArgs ...string) string {
 	url, err := r.Get(path).URL(pathArgs...)
 	if err != nil {
@@ -30,3 +69,19 @@ func Reverse(path, fallback string, r *mux.Router, pathArgs ...string) string {
 
 	return url.Path
 }
+
+// actor returns who is making the request, for the audit log.
+// An admin impersonating a user is the actor, not the user.
+func actor(r *http.Request) models.Actor {
+	a := models.Actor{
+		IP:        lib.ClientIP(r),
+		RequestID: context.RequestID(r.Context()),
+	}
+	if admin := context.Impersonator(r.Context()); admin != nil {
+		a.UserID = admin.ID
+	} else if user := context.User(r.Context()); user != nil {
+		a.UserID = user.ID
+	}
+
+	return a
+}
@@ -0,0 +1,77 @@
+package email
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPConfig describes the SMTP server used to send emails.
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// NewSMTP creates a Mailer sending emails through an SMTP
+// server. Authentication is only used when a username is set.
+func NewSMTP(cfg SMTPConfig) Mailer {
+	return &smtpMailer{cfg: cfg}
+}
+
+type smtpMailer struct {
+	cfg SMTPConfig
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+
+	addr := net.JoinHostPort(m.cfg.Host, m.cfg.Port)
+	return smtp.SendMail(addr, auth, m.cfg.From, []string{to}, message(m.cfg.From, to, subject, body))
+}
+
+// NewLogMailer creates a Mailer writing emails to the logger
+// instead of sending them, for development.
+func NewLogMailer(l *log.Logger) Mailer {
+	return &logMailer{l: l}
+}
+
+type logMailer struct {
+	l *log.Logger
+}
+
+func (m *logMailer) Send(to, subject, body string) error {
+	m.l.Printf("email to %s: %s\n%s\n", to, subject, body)
+	return nil
+}
+
+func message(from, to, subject, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", stripNewlines(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// stripNewlines prevents header injection through the subject.
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
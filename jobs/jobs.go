@@ -0,0 +1,77 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Runner runs background jobs, either periodically or once,
+// and waits for them to finish when it is stopped. The jobs
+// get a context cancelled by Stop.
+type Runner struct {
+	l      *log.Logger
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewRunner(l *log.Logger) *Runner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runner{
+		l:      l,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Every runs fn every interval until the runner is stopped.
+// The first run happens right away. Errors are logged, they
+// don't stop the job.
+func (r *Runner) Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			r.run(name, fn)
+
+			select {
+			case <-t.C:
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Go runs fn once in the background. Stop waits for it.
+func (r *Runner) Go(name string, fn func(ctx context.Context) error) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.run(name, fn)
+	}()
+}
+
+// Stop stops the periodic jobs, cancels the context of the
+// running ones and waits for them to finish.
+func (r *Runner) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+func (r *Runner) run(name string, fn func(ctx context.Context) error) {
+	defer func() {
+		if err := recover(); err != nil {
+			r.l.Printf("job %s panicked: %v\n", name, err)
+		}
+	}()
+
+	if err := fn(r.ctx); err != nil {
+		r.l.Printf("job %s failed: %s\n", name, err)
+	}
+}
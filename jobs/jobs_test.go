@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunnerEvery(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRunner(log.New(&buf, "", 0))
+
+	var n int32
+	r.Every("count", time.Millisecond, func(ctx context.Context) error {
+		if atomic.AddInt32(&n, 1) == 1 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	time.Sleep(20 * time.Millisecond)
+	r.Stop()
+
+	ran := atomic.LoadInt32(&n)
+	if ran < 2 {
+		t.Fatalf("job ran %d times, want at least 2", ran)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if atomic.LoadInt32(&n) != ran {
+		t.Fatal("job kept running after Stop")
+	}
+
+	if !strings.Contains(buf.String(), "job count failed: boom") {
+		t.Errorf("log = %q, want the job error", buf.String())
+	}
+}
+
+func TestRunnerGo(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRunner(log.New(&buf, "", 0))
+
+	done := false
+	r.Go("once", func(ctx context.Context) error {
+		time.Sleep(5 * time.Millisecond)
+		done = true
+		return nil
+	})
+	r.Go("panics", func(ctx context.Context) error { panic("oops") })
+	r.Stop()
+
+	if !done {
+		t.Fatal("Stop returned before the job finished")
+	}
+	if !strings.Contains(buf.String(), "job panics panicked: oops") {
+		t.Errorf("log = %q, want the panic", buf.String())
+	}
+}
+
+func TestRunnerStopCancels(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRunner(log.New(&buf, "", 0))
+
+	started := make(chan struct{})
+	var err error
+	r.Go("wait", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		err = ctx.Err()
+		return nil
+	})
+	<-started
+	r.Stop()
+
+	if err != context.Canceled {
+		t.Errorf("job context err = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// ErrCiphertextTooShort is returned when decrypting a value
+// that can't have been produced by Encrypt.
+var ErrCiphertextTooShort = errors.New("lib: ciphertext too short")
+
+// NewCipher creates an AES-256-GCM cipher. The AES key
+// is derived from key using SHA-256.
+func NewCipher(key string) (Cipher, error) {
+	k := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return Cipher{}, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return Cipher{}, err
+	}
+
+	return Cipher{aead: gcm}, nil
+}
+
+// Cipher is used to encrypt secrets stored in the database.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// Encrypt returns the base64 URL encoded nonce
+// followed by the sealed plaintext.
+func (c Cipher) Encrypt(plaintext string) (string, error) {
+	nonce, err := Bytes(c.aead.NonceSize())
+	if err != nil {
+		return "", err
+	}
+
+	b := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// Decrypt opens a value returned by Encrypt.
+func (c Cipher) Decrypt(ciphertext string) (string, error) {
+	b, err := base64.URLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	n := c.aead.NonceSize()
+	if len(b) < n {
+		return "", ErrCiphertextTooShort
+	}
+
+	plaintext, err := c.aead.Open(nil, b[:n], b[n:], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
@@ -1,21 +1,145 @@
 package lib
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"soramon0/webapp/utils"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// The database drivers of DB_DRIVER.
+const (
+	DriverPostgres = "postgres"
+	// DriverSQLite is an embedded, pure Go, SQLite database for
+	// development and single node setups.
+	DriverSQLite = "sqlite"
+)
+
 func InitDB() *gorm.DB {
-	psqlDialector := postgres.New(postgres.Config{
-		DSN:                  utils.GetDB(),
-		PreferSimpleProtocol: true, // disables implicit prepared statement usage
-	})
-	db, err := gorm.Open(psqlDialector, &gorm.Config{})
+	dsn := utils.GetDB()
+	if utils.GetDBDriver() == DriverSQLite {
+		dsn = utils.GetDBPath()
+	}
+
+	db, err := OpenDB(utils.GetDBDriver(), dsn)
 	if err != nil {
 		panic(err)
 	}
 
 	return db
 }
+
+// OpenDB connects to the database of driver. The dsn of SQLite
+// is the path of the database file, or :memory:. Every query
+// is cancelled past utils.GetQueryTimeout().
+func OpenDB(driver, dsn string) (*gorm.DB, error) {
+	var db *gorm.DB
+	var err error
+	switch driver {
+	case DriverPostgres:
+		psqlDialector := postgres.New(postgres.Config{
+			DSN:                  dsn,
+			PreferSimpleProtocol: true, // disables implicit prepared statement usage
+		})
+		db, err = gorm.Open(psqlDialector, &gorm.Config{})
+	case DriverSQLite:
+		db, err = openSQLite(dsn)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q, use %s or %s", driver, DriverPostgres, DriverSQLite)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return db, withQueryTimeout(db, utils.GetQueryTimeout())
+}
+
+// queryTimeoutKey is where the query context is kept between
+// the callbacks.
+const queryTimeoutKey = "lib:query_timeout"
+
+type queryTimeout struct {
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+// withQueryTimeout gives each query but Row and Rows d to
+// complete. The deadline is set per query rather than per
+// request, so the time spent reading a slow upload does not
+// count against the queries made once it is read.
+func withQueryTimeout(db *gorm.DB, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	start := func(db *gorm.DB) {
+		// A statement can run several queries (e.g. Count and
+		// Find), each one gets d from the original context.
+		parent := db.Statement.Context
+		if qt, ok := db.InstanceGet(queryTimeoutKey); ok {
+			parent = qt.(*queryTimeout).parent
+		}
+
+		ctx, cancel := context.WithTimeout(parent, d)
+		db.Statement.Context = ctx
+		db.InstanceSet(queryTimeoutKey, &queryTimeout{parent: parent, cancel: cancel})
+	}
+	end := func(db *gorm.DB) {
+		if qt, ok := db.InstanceGet(queryTimeoutKey); ok {
+			qt.(*queryTimeout).cancel()
+			db.Statement.Context = qt.(*queryTimeout).parent
+		}
+	}
+
+	// Row and Rows (used by Raw(...).Scan too) are left out,
+	// their result is read once the callbacks returned and
+	// nothing would release the timer until the deadline.
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("*").Register("lib:query_timeout_start", start),
+		cb.Create().After("*").Register("lib:query_timeout_end", end),
+		cb.Query().Before("*").Register("lib:query_timeout_start", start),
+		cb.Query().After("*").Register("lib:query_timeout_end", end),
+		cb.Update().Before("*").Register("lib:query_timeout_start", start),
+		cb.Update().After("*").Register("lib:query_timeout_end", end),
+		cb.Delete().Before("*").Register("lib:query_timeout_start", start),
+		cb.Delete().After("*").Register("lib:query_timeout_end", end),
+		cb.Raw().Before("*").Register("lib:query_timeout_start", start),
+		cb.Raw().After("*").Register("lib:query_timeout_end", end),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func openSQLite(path string) (*gorm.DB, error) {
+	dsn := path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		// SQLite compares times as text, they must all have
+		// the same time zone to sort right.
+		NowFunc: func() time.Time {
+			return time.Now().UTC()
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer, and every connection to
+	// :memory: would open a new empty database.
+	sqlDB.SetMaxOpenConns(1)
+
+	return db, nil
+}
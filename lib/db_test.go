@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func testingQueryTimeout(t *testing.T, d time.Duration) *gorm.DB {
+	db, err := openSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := withQueryTimeout(db, d); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// slowReader returns one byte of r every delay, like a
+// client uploading slowly.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.r.Read(p[:1])
+}
+
+func TestQueryTimeoutSlowBody(t *testing.T) {
+	db := testingQueryTimeout(t, 50*time.Millisecond)
+
+	var n int
+	var err error
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if _, err = ioutil.ReadAll(r.Body); err != nil {
+			return
+		}
+		err = db.WithContext(r.Context()).Raw("SELECT 1").Find(&n).Error
+	}
+
+	// Reading the body takes twice the query timeout.
+	body := slowReader{r: strings.NewReader("images"), delay: 20 * time.Millisecond}
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", body))
+	if err != nil || n != 1 {
+		t.Fatalf("query after a slow body = %d, %v; want 1, nil", n, err)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db := testingQueryTimeout(t, time.Minute)
+
+	var deadline time.Time
+	var ok bool
+	err := db.Callback().Query().Before("gorm:query").Register("test:deadline", func(db *gorm.DB) {
+		deadline, ok = db.Statement.Context.Deadline()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	var n int64
+	if err := db.WithContext(context.Background()).Table("sqlite_master").Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now()
+
+	if !ok {
+		t.Fatal("query context has no deadline")
+	}
+	if deadline.Before(start.Add(time.Minute)) || deadline.After(end.Add(time.Minute)) {
+		t.Errorf("deadline = %s, want a minute after the query started", deadline)
+	}
+
+	// The context of the first query is cancelled once it is
+	// done, the second one must not inherit it.
+	q := db.WithContext(context.Background()).Table("sqlite_master")
+	var names []string
+	if err := q.Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Pluck("name", &names).Error; err != nil {
+		t.Errorf("second query of a statement = %v", err)
+	}
+
+	// Row is read after its callbacks, nothing would cancel
+	// its context before the deadline.
+	err = db.Callback().Row().Before("gorm:row").Register("test:deadline", func(db *gorm.DB) {
+		_, ok = db.Statement.Context.Deadline()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WithContext(context.Background()).Raw("SELECT 1").Row().Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("Row context has a deadline")
+	}
+}
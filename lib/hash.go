@@ -4,26 +4,173 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"hash"
+	"errors"
+	"fmt"
+	"strings"
+
+	"soramon0/webapp/utils"
 )
 
-// NewHMAC creates and returns a new HMAC object
+// ErrInvalidSignature is returned by Verify when the signed
+// value was not signed with one of our keys or was tampered with.
+var ErrInvalidSignature = errors.New("lib: invalid signature")
+
+// HMACKey is one key of an HMAC keyring. The ID is stored
+// with every hash so the key can be found again once it
+// is no longer the primary one.
+type HMACKey struct {
+	ID  string
+	Key []byte
+}
+
+// ParseHMACKeys parses a comma separated "id:key" list.
+func ParseHMACKeys(s string) ([]HMACKey, error) {
+	var keys []HMACKey
+	for _, k := range strings.Split(s, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		i := strings.Index(k, ":")
+		if i <= 0 || i == len(k)-1 {
+			return nil, fmt.Errorf("lib: invalid HMAC key %q, expected id:key", k)
+		}
+		keys = append(keys, HMACKey{ID: k[:i], Key: []byte(k[i+1:])})
+	}
+	return keys, nil
+}
+
+// NewHMAC creates and returns a new HMAC object with a
+// single key.
 func NewHMAC(key string) HMAC {
-	h := hmac.New(sha256.New, []byte(key))
-	return HMAC{hmac: h}
+	return HMAC{keys: []HMACKey{{ID: "0", Key: []byte(key)}}}
+}
+
+// NewHMACKeyring creates an HMAC object from a list of keys.
+// The first key is the primary one, used for new hashes, the
+// others are only used for verification. The legacy key, if
+// not empty, verifies the hashes made before they carried a
+// key ID.
+func NewHMACKeyring(keys []HMACKey, legacy string) (HMAC, error) {
+	if len(keys) == 0 {
+		return HMAC{}, errors.New("lib: HMAC keyring needs at least one key")
+	}
+
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.ID == "" || strings.ContainsAny(k.ID, ":.") {
+			return HMAC{}, fmt.Errorf("lib: invalid HMAC key ID %q", k.ID)
+		}
+		if seen[k.ID] {
+			return HMAC{}, fmt.Errorf("lib: duplicate HMAC key ID %q", k.ID)
+		}
+		seen[k.ID] = true
+	}
+
+	h := HMAC{keys: append([]HMACKey{}, keys...)}
+	if legacy != "" {
+		h.legacy = []byte(legacy)
+	}
+
+	return h, nil
+}
+
+// NewHMACFromEnv builds the keyring from HMAC_KEYS. Unless
+// HMAC_KEEP_SECRET is false, SECRET is kept as key "0" and as
+// the legacy key, so changing the keys does not log everyone
+// out. It can be dropped once the hashes have been rotated.
+func NewHMACFromEnv() (HMAC, error) {
+	return newHMACFromConfig(utils.GetHMACKeys(), utils.GetSecret(), utils.GetHMACKeepSecret())
+}
+
+func newHMACFromConfig(hmacKeys, secret string, keepSecret bool) (HMAC, error) {
+	keys, err := ParseHMACKeys(hmacKeys)
+	if err != nil {
+		return HMAC{}, err
+	}
+
+	// Without HMAC_KEYS, SECRET is the only key there is.
+	if !keepSecret && len(keys) > 0 {
+		return NewHMACKeyring(keys, "")
+	}
+
+	k := HMACKey{ID: "0", Key: []byte(secret)}
+	hasSecret := false
+	for _, key := range keys {
+		hasSecret = hasSecret || key.ID == k.ID
+	}
+	if !hasSecret {
+		keys = append(keys, k)
+	}
+
+	if !keepSecret {
+		return NewHMACKeyring(keys, "")
+	}
+	return NewHMACKeyring(keys, secret)
 }
 
 // HMAC is a wrapper around the crypto/hmac package making
-// it a little easier to use in our code
+// it a little easier to use in our code. It is safe for
+// concurrent use.
 type HMAC struct {
-	hmac hash.Hash
+	keys   []HMACKey
+	legacy []byte
 }
 
 // Hash will hash the provided input string using HMAC with
-// the secret key provided when the HMAC object was created
+// the primary key. The result is prefixed with the key ID:
+// <id>:<base64 HMAC>
 func (h HMAC) Hash(input string) string {
-	h.hmac.Reset()
-	h.hmac.Write([]byte(input))
-	b := h.hmac.Sum(nil)
-	return base64.URLEncoding.EncodeToString(b)
+	k := h.keys[0]
+	return k.ID + ":" + sum(k.Key, input)
+}
+
+// Hashes returns the hash of input under every key, starting
+// with the primary one. It is used to look up values hashed
+// with an older key.
+func (h HMAC) Hashes(input string) []string {
+	hashes := make([]string, 0, len(h.keys)+1)
+	for _, k := range h.keys {
+		hashes = append(hashes, k.ID+":"+sum(k.Key, input))
+	}
+	if h.legacy != nil {
+		hashes = append(hashes, sum(h.legacy, input))
+	}
+	return hashes
+}
+
+// IsPrimary reports whether hash was made with the primary key.
+func (h HMAC) IsPrimary(hash string) bool {
+	return strings.HasPrefix(hash, h.keys[0].ID+":")
+}
+
+// Sign returns value followed by its HMAC, so it can be
+// handed to the client (e.g. in a cookie) and trusted
+// when it comes back.
+func (h HMAC) Sign(value string) string {
+	return value + "." + h.Hash(value)
+}
+
+// Verify checks the signature of a value returned by Sign,
+// with any key of the keyring, and returns the original value.
+func (h HMAC) Verify(signed string) (string, error) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", ErrInvalidSignature
+	}
+
+	value, sig := signed[:i], signed[i+1:]
+	for _, hash := range h.Hashes(value) {
+		if hmac.Equal([]byte(sig), []byte(hash)) {
+			return value, nil
+		}
+	}
+
+	return "", ErrInvalidSignature
+}
+
+func sum(key []byte, input string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(input))
+	return base64.URLEncoding.EncodeToString(m.Sum(nil))
 }
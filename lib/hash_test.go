@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func testingKeyring(t *testing.T, legacy string, keys ...HMACKey) HMAC {
+	h, err := NewHMACKeyring(keys, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHMACRotation(t *testing.T) {
+	old := testingKeyring(t, "", HMACKey{ID: "1", Key: []byte("old")})
+	rotated := testingKeyring(t, "",
+		HMACKey{ID: "2", Key: []byte("new")},
+		HMACKey{ID: "1", Key: []byte("old")},
+	)
+
+	hash := old.Hash("token")
+	if rotated.IsPrimary(hash) {
+		t.Fatalf("IsPrimary(%q) = true after rotation", hash)
+	}
+
+	found := false
+	for _, h := range rotated.Hashes("token") {
+		found = found || h == hash
+	}
+	if !found {
+		t.Fatalf("Hashes does not contain the old hash %q", hash)
+	}
+
+	if !rotated.IsPrimary(rotated.Hash("token")) {
+		t.Fatal("IsPrimary(Hash) = false")
+	}
+
+	value, err := rotated.Verify(old.Sign("value"))
+	if err != nil || value != "value" {
+		t.Fatalf("Verify = %q, %v; want value, nil", value, err)
+	}
+}
+
+func TestHMACLegacy(t *testing.T) {
+	m := hmac.New(sha256.New, []byte("secret"))
+	m.Write([]byte("token"))
+	legacy := base64.URLEncoding.EncodeToString(m.Sum(nil))
+
+	h := testingKeyring(t, "secret", HMACKey{ID: "1", Key: []byte("new")})
+	hashes := h.Hashes("token")
+	if hashes[len(hashes)-1] != legacy {
+		t.Fatalf("Hashes does not end with the legacy hash %q", legacy)
+	}
+	if h.IsPrimary(legacy) {
+		t.Fatal("IsPrimary(legacy) = true")
+	}
+
+	if _, err := h.Verify("value.bogus"); err != ErrInvalidSignature {
+		t.Fatalf("Verify = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestHMACDropSecret(t *testing.T) {
+	kept, err := newHMACFromConfig("1:new", "secret", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := newHMACFromConfig("1:new", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := testingKeyring(t, "secret", HMACKey{ID: "0", Key: []byte("secret")})
+	m := hmac.New(sha256.New, []byte("secret"))
+	m.Write([]byte("value"))
+	signed := []string{
+		legacy.Sign("value"),
+		"value." + base64.URLEncoding.EncodeToString(m.Sum(nil)),
+	}
+
+	for _, s := range signed {
+		if _, err := kept.Verify(s); err != nil {
+			t.Errorf("Verify(%q) = %v with SECRET kept", s, err)
+		}
+		if _, err := dropped.Verify(s); err != ErrInvalidSignature {
+			t.Errorf("Verify(%q) = %v with SECRET dropped, want ErrInvalidSignature", s, err)
+		}
+	}
+
+	if _, err := dropped.Verify(dropped.Sign("value")); err != nil {
+		t.Fatalf("Verify(Sign) = %v", err)
+	}
+}
+
+func TestHMACKeyringInvalid(t *testing.T) {
+	cases := [][]HMACKey{
+		nil,
+		{{ID: "", Key: []byte("k")}},
+		{{ID: "a:b", Key: []byte("k")}},
+		{{ID: "1", Key: []byte("a")}, {ID: "1", Key: []byte("b")}},
+	}
+	for _, keys := range cases {
+		if _, err := NewHMACKeyring(keys, ""); err == nil {
+			t.Errorf("NewHMACKeyring(%v) = nil error", keys)
+		}
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	h := NewHMAC("secret")
+	now := time.Now()
+	imp := Impersonation{AdminID: 1, UserID: 2, ExpiresAt: now.Add(time.Hour).Truncate(time.Second)}
+
+	signed := h.SignImpersonation(imp)
+	got, err := h.VerifyImpersonation(signed, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != imp {
+		t.Fatalf("VerifyImpersonation = %+v, want %+v", got, imp)
+	}
+
+	if _, err := h.VerifyImpersonation(signed, now.Add(2*time.Hour)); err != ErrInvalidSignature {
+		t.Errorf("expired: err = %v, want ErrInvalidSignature", err)
+	}
+	if _, err := h.VerifyImpersonation(h.Sign("2fa:2:9999999999"), now); err != ErrInvalidSignature {
+		t.Errorf("other purpose: err = %v, want ErrInvalidSignature", err)
+	}
+}
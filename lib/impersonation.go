@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ImpersonationCookie holds the signed Impersonation of
+	// an admin acting as another user.
+	ImpersonationCookie = "impersonate"
+	// impersonationPurpose is signed along the session so the
+	// cookie can't be mistaken for another signed value.
+	impersonationPurpose = "impersonate"
+)
+
+// Impersonation is the session flag of an admin acting as
+// another user for support.
+type Impersonation struct {
+	AdminID   uint
+	UserID    uint
+	ExpiresAt time.Time
+}
+
+// SignImpersonation returns the signed cookie value of imp.
+func (h HMAC) SignImpersonation(imp Impersonation) string {
+	return h.Sign(strings.Join([]string{
+		impersonationPurpose,
+		strconv.Itoa(int(imp.AdminID)),
+		strconv.Itoa(int(imp.UserID)),
+		strconv.FormatInt(imp.ExpiresAt.Unix(), 10),
+	}, ":"))
+}
+
+// VerifyImpersonation returns the impersonation signed by
+// SignImpersonation. It returns ErrInvalidSignature if the value
+// is invalid or expired at time t.
+func (h HMAC) VerifyImpersonation(signed string, t time.Time) (Impersonation, error) {
+	value, err := h.Verify(signed)
+	if err != nil {
+		return Impersonation{}, err
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 || parts[0] != impersonationPurpose {
+		return Impersonation{}, ErrInvalidSignature
+	}
+
+	adminID, err1 := strconv.ParseUint(parts[1], 10, 0)
+	userID, err2 := strconv.ParseUint(parts[2], 10, 0)
+	expires, err3 := strconv.ParseInt(parts[3], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return Impersonation{}, ErrInvalidSignature
+	}
+
+	imp := Impersonation{
+		AdminID:   uint(adminID),
+		UserID:    uint(userID),
+		ExpiresAt: time.Unix(expires, 0),
+	}
+	if !t.Before(imp.ExpiresAt) {
+		return Impersonation{}, ErrInvalidSignature
+	}
+
+	return imp, nil
+}
@@ -0,0 +1,17 @@
+package lib
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP returns the IP address of the client that
+// made the request, without the port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,240 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+
+	// passwordHashVersion prefixes the hashes we produce:
+	// v1:<pepper ID>:<bcrypt or argon2id PHC string>
+	passwordHashVersion = "v1"
+	argon2SaltBytes     = 16
+	argon2KeyBytes      = 32
+)
+
+var (
+	ErrUnknownPepper    = errors.New("lib: password hash uses an unknown pepper")
+	ErrUnknownAlgorithm = errors.New("lib: unknown password hashing algorithm")
+	ErrMalformedHash    = errors.New("lib: malformed password hash")
+)
+
+// Pepper is a secret mixed into every password before it is
+// hashed. Peppers have an ID so they can be rotated.
+type Pepper struct {
+	ID  string
+	Key string
+}
+
+// ParsePeppers parses a comma separated "id:key" list.
+func ParsePeppers(s string) ([]Pepper, error) {
+	var peppers []Pepper
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		i := strings.Index(p, ":")
+		if i <= 0 || i == len(p)-1 {
+			return nil, fmt.Errorf("lib: invalid pepper %q, expected id:key", p)
+		}
+		peppers = append(peppers, Pepper{ID: p[:i], Key: p[i+1:]})
+	}
+	return peppers, nil
+}
+
+type Argon2Params struct {
+	// Memory is in KiB.
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// PasswordPolicy describes how new passwords are hashed.
+// Hashes made with another policy still verify, and are
+// reported as needing a rehash.
+type PasswordPolicy struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+	// Peppers are tried by ID, the first one is used
+	// for new hashes.
+	Peppers []Pepper
+	// LegacyPepper was appended to the password of the plain
+	// bcrypt hashes made before hashes were versioned.
+	LegacyPepper string
+}
+
+// PasswordHasher hashes and verifies passwords.
+type PasswordHasher struct {
+	policy PasswordPolicy
+}
+
+func NewPasswordHasher(policy PasswordPolicy) (*PasswordHasher, error) {
+	if len(policy.Peppers) == 0 {
+		return nil, errors.New("lib: at least one pepper is required")
+	}
+
+	switch policy.Algorithm {
+	case AlgorithmBcrypt:
+		if policy.BcryptCost < bcrypt.MinCost || policy.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("lib: invalid bcrypt cost %d", policy.BcryptCost)
+		}
+	case AlgorithmArgon2id:
+		if policy.Argon2.Memory == 0 || policy.Argon2.Time == 0 || policy.Argon2.Threads == 0 {
+			return nil, errors.New("lib: invalid argon2id parameters")
+		}
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+
+	return &PasswordHasher{policy: policy}, nil
+}
+
+// Hash hashes password with the current policy.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	pepper := h.policy.Peppers[0]
+	peppered := h.pepper(pepper.Key, password)
+
+	var inner string
+	switch h.policy.Algorithm {
+	case AlgorithmBcrypt:
+		b, err := bcrypt.GenerateFromPassword(peppered, h.policy.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		inner = string(b)
+	case AlgorithmArgon2id:
+		salt, err := Bytes(argon2SaltBytes)
+		if err != nil {
+			return "", err
+		}
+		inner = encodeArgon2(h.policy.Argon2, salt, argon2Key(h.policy.Argon2, peppered, salt))
+	}
+
+	return strings.Join([]string{passwordHashVersion, pepper.ID, inner}, ":"), nil
+}
+
+// Verify checks password against hash. needsRehash is true when
+// the password matches but the hash does not follow the current
+// policy (algorithm, cost or pepper), so the caller should hash
+// the password again.
+func (h *PasswordHasher) Verify(hash, password string) (ok, needsRehash bool, err error) {
+	if strings.HasPrefix(hash, "$2") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password+h.policy.LegacyPepper))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		return err == nil, true, err
+	}
+
+	parts := strings.SplitN(hash, ":", 3)
+	if len(parts) != 3 || parts[0] != passwordHashVersion {
+		return false, false, ErrMalformedHash
+	}
+	pepperID, inner := parts[1], parts[2]
+
+	key, ok := h.pepperKey(pepperID)
+	if !ok {
+		return false, false, ErrUnknownPepper
+	}
+	peppered := h.pepper(key, password)
+	outdated := pepperID != h.policy.Peppers[0].ID
+
+	switch {
+	case strings.HasPrefix(inner, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(inner), peppered)
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(inner))
+		if err != nil {
+			return false, false, err
+		}
+		outdated = outdated || h.policy.Algorithm != AlgorithmBcrypt || cost != h.policy.BcryptCost
+		return true, outdated, nil
+	case strings.HasPrefix(inner, "$"+AlgorithmArgon2id+"$"):
+		params, salt, key, err := decodeArgon2(inner)
+		if err != nil {
+			return false, false, err
+		}
+		if subtle.ConstantTimeCompare(key, argon2Key(params, peppered, salt)) != 1 {
+			return false, false, nil
+		}
+		outdated = outdated || h.policy.Algorithm != AlgorithmArgon2id || params != h.policy.Argon2
+		return true, outdated, nil
+	}
+
+	return false, false, ErrUnknownAlgorithm
+}
+
+func (h *PasswordHasher) pepperKey(id string) (string, bool) {
+	for _, p := range h.policy.Peppers {
+		if p.ID == id {
+			return p.Key, true
+		}
+	}
+	return "", false
+}
+
+// pepper returns the HMAC of password keyed with the pepper.
+// Unlike appending the pepper, it keeps the input short
+// enough for bcrypt's 72 bytes limit.
+func (h *PasswordHasher) pepper(key, password string) []byte {
+	m := hmac.New(sha256.New, []byte(key))
+	m.Write([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(m.Sum(nil)))
+}
+
+func argon2Key(p Argon2Params, password, salt []byte) []byte {
+	return argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, argon2KeyBytes)
+}
+
+// encodeArgon2 returns the PHC string format of the hash.
+func encodeArgon2(p Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		AlgorithmArgon2id, argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2(s string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, ErrMalformedHash
+	}
+
+	return p, salt, key, nil
+}
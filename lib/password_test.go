@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testingHasher(t *testing.T, algorithm string, peppers ...Pepper) *PasswordHasher {
+	h, err := NewPasswordHasher(PasswordPolicy{
+		Algorithm:    algorithm,
+		BcryptCost:   bcrypt.MinCost,
+		Argon2:       Argon2Params{Memory: 64, Time: 1, Threads: 1},
+		Peppers:      peppers,
+		LegacyPepper: "legacy",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestPasswordHasherRoundTrip(t *testing.T) {
+	for _, alg := range []string{AlgorithmBcrypt, AlgorithmArgon2id} {
+		h := testingHasher(t, alg, Pepper{ID: "1", Key: "pepper"})
+		hash, err := h.Hash("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ok, rehash, err := h.Verify(hash, "password")
+		if err != nil || !ok || rehash {
+			t.Errorf("%s: Expected valid and up to date. Recieved ok=%v rehash=%v err=%v", alg, ok, rehash, err)
+		}
+
+		if ok, _, _ := h.Verify(hash, "wrong"); ok {
+			t.Errorf("%s: Expected wrong password to fail", alg)
+		}
+	}
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("password"+"legacy"), bcrypt.MinCost)
+	old := testingHasher(t, AlgorithmBcrypt, Pepper{ID: "1", Key: "old"})
+	oldHash, _ := old.Hash("password")
+
+	// The pepper was rotated and the algorithm changed.
+	h := testingHasher(t, AlgorithmArgon2id, Pepper{ID: "2", Key: "new"}, Pepper{ID: "1", Key: "old"})
+
+	for _, hash := range []string{string(legacy), oldHash} {
+		ok, rehash, err := h.Verify(hash, "password")
+		if err != nil || !ok || !rehash {
+			t.Errorf("Expected valid hash needing rehash. Recieved ok=%v rehash=%v err=%v", ok, rehash, err)
+		}
+	}
+
+	removed := testingHasher(t, AlgorithmBcrypt, Pepper{ID: "2", Key: "new"})
+	if _, _, err := removed.Verify(oldHash, "password"); err != ErrUnknownPepper {
+		t.Errorf("Expected ErrUnknownPepper. Recieved %v", err)
+	}
+}
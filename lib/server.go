@@ -3,6 +3,7 @@ package lib
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,6 +17,10 @@ import (
 )
 
 func NewServer(l *log.Logger, wg *sync.WaitGroup, r *mux.Router) *Server {
+	// The requests, and their queries, are cancelled if they
+	// are still running when the shutdown times out.
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Server{
 		Server: http.Server{
 			Addr:         utils.GetBindAdress(),
@@ -24,9 +29,13 @@ func NewServer(l *log.Logger, wg *sync.WaitGroup, r *mux.Router) *Server {
 			ReadTimeout:  5 * time.Second,   // max time to read request from the client
 			WriteTimeout: 10 * time.Second,  // max time to write response to the client
 			IdleTimeout:  120 * time.Second, // max time for connections using TCP Keep-Alive
+			BaseContext: func(net.Listener) context.Context {
+				return ctx
+			},
 		},
-		l:  l,
-		wg: wg,
+		l:      l,
+		wg:     wg,
+		cancel: cancel,
 	}
 }
 
@@ -62,13 +71,15 @@ func (s *Server) GracefulShutdown() {
 	// gracefully shutdown the server, waiting max 30 seconds for current operations to complete
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
+	defer s.cancel()
 	if err := s.Shutdown(ctx); err != nil {
-		s.l.Fatal(err)
+		s.l.Printf("Error shutting down: %s, cancelling the running requests\n", err)
 	}
 }
 
 type Server struct {
 	http.Server
-	l  *log.Logger
-	wg *sync.WaitGroup
+	l      *log.Logger
+	wg     *sync.WaitGroup
+	cancel context.CancelFunc
 }
@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPSecretBytes is the size of the secrets we generate,
+	// 160 bits as recommended by RFC 4226.
+	TOTPSecretBytes = 20
+	TOTPDigits      = 6
+	TOTPPeriod      = 30 * time.Second
+	// TOTPSkew is the number of periods before and after
+	// the current one that are still accepted.
+	TOTPSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPSecret generates a new base32 encoded TOTP secret.
+func TOTPSecret() (string, error) {
+	b, err := Bytes(TOTPSecretBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPStep returns the time step t falls in.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+// TOTPCode returns the code for secret at the given time step
+// as described in RFC 6238 using HMAC-SHA1.
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	m := hmac.New(sha1.New, key)
+	m.Write(msg[:])
+	sum := m.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTPDigits, bin%mod), nil
+}
+
+// ValidateTOTP checks code against secret at time t, allowing
+// TOTPSkew periods of clock drift. It returns the matched time
+// step so callers can refuse a code being used twice, or -1 if
+// the code is not valid.
+func ValidateTOTP(secret, code string, t time.Time) (int64, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return -1, nil
+	}
+
+	current := TOTPStep(t)
+	for step := current - TOTPSkew; step <= current+TOTPSkew; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return -1, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// TOTPURL returns the otpauth:// URL authenticator apps
+// expect in the enrollment QR code.
+func TOTPURL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("digits", fmt.Sprint(TOTPDigits))
+	v.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
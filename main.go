@@ -1,27 +1,122 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/nicholasjackson/env"
 
+	"soramon0/webapp/jobs"
 	"soramon0/webapp/lib"
 	"soramon0/webapp/models"
 	"soramon0/webapp/routes"
 	"soramon0/webapp/utils"
 )
 
+const usage = `Usage: webapp [command] [flags]
+
+Commands:
+  serve                    run the server, the default
+  migrate up               apply the pending migrations
+  migrate down             revert the last applied migration
+  migrate to VERSION       migrate up or down to VERSION, 0 reverts all
+  migrate status           list the migrations and when they were applied
+  create-user              create a user, see create-user -h
+  reset-password           set a new random password, see reset-password -h
+  promote                  change the role of a user, see promote -h
+  reset -confirm           drop all the data and migrate again
+  seed                     create users, galleries and images, see seed -h
+  reindex-images           check the images on disk, see reindex-images -h
+
+Configuration is read from the environment, see webapp -help.
+`
+
+// command is a subcommand of the CLI, run with the
+// arguments after its name. Commands parse their flags
+// before connecting to the database.
+type command func(args []string) error
+
+var commands = map[string]command{
+	"migrate":        migrate,
+	"create-user":    createUser,
+	"reset-password": resetPassword,
+	"promote":        promote,
+	"reset":          reset,
+	"seed":           seed,
+	"reindex-images": reindexImages,
+}
+
 func main() {
 	utils.Must(env.Parse())
 
+	name := flag.Arg(0)
+	if name == "" || name == "serve" {
+		serve()
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+
+	err := cmd(flag.Args()[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func serve() {
 	wg := &sync.WaitGroup{}
 	l := lib.InitLogger()
 
 	services := models.NewServices()
-	utils.Must(services.AutoMigrate())
+	utils.Must(services.Migrate())
 	defer services.Close()
 
-	r := routes.Register(services, wg, l)
+	jr := jobs.NewRunner(l)
+	defer jr.Stop()
+	jr.Every("purge deleted accounts", utils.GetPurgeInterval(), func(ctx context.Context) error {
+		n, err := services.PurgeDeletedUsers(ctx, time.Now())
+		if n > 0 {
+			l.Printf("purged %d deleted accounts\n", n)
+		}
+		return err
+	})
+	jr.Every("purge trashed galleries", utils.GetPurgeInterval(), func(ctx context.Context) error {
+		t := time.Now().Add(-utils.GetGalleryTrashRetention())
+		n, err := services.PurgeDeletedGalleries(ctx, t)
+		if n > 0 {
+			l.Printf("purged %d trashed galleries\n", n)
+		}
+		return err
+	})
+	jr.Every("purge expired exports", utils.GetPurgeInterval(), func(ctx context.Context) error {
+		// Exports pending past the timeout were left behind by a
+		// process which died, they would block new exports.
+		t := time.Now().Add(-utils.GetExportTimeout())
+		n, err := services.Export.FailStale(ctx, t)
+		if n > 0 {
+			l.Printf("failed %d stale exports\n", n)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = services.Export.PurgeExpired(ctx, time.Now())
+		return err
+	})
+
+	r := routes.Register(services, wg, jr, l)
 	s := lib.NewServer(l, wg, r)
 
 	go s.Start()
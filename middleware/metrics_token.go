@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type metricsToken struct {
+	token string
+}
+
+// NewMetricsToken creates a middleware only letting through the
+// requests with token in their Authorization header, as a
+// bearer token. Every request is refused when token is empty.
+func NewMetricsToken(token string) *metricsToken {
+	return &metricsToken{token: token}
+}
+
+// Middleware function, which will be called for each request
+func (mw *metricsToken) Middleware(next http.Handler) http.Handler {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *metricsToken) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn answers 404 to the refused requests, so the endpoint
+// looks like it doesn't exist.
+func (mw *metricsToken) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if mw.token == "" || token == auth ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(mw.token)) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
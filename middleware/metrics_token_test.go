@@ -0,0 +1,38 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"soramon0/webapp/middleware"
+)
+
+func TestMetricsToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name  string
+		token string
+		auth  string
+		want  int
+	}{
+		{"valid token", "s3cret", "Bearer s3cret", http.StatusOK},
+		{"wrong token", "s3cret", "Bearer other", http.StatusNotFound},
+		{"no bearer prefix", "s3cret", "s3cret", http.StatusNotFound},
+		{"no header", "s3cret", "", http.StatusNotFound},
+		{"disabled", "", "Bearer ", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		h := middleware.NewMetricsToken(tc.token).Apply(ok)
+		r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
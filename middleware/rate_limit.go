@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+	"soramon0/webapp/ratelimit"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimitStats is published on /debug/vars. It holds the
+// allowed and limited request counts of every route and the
+// number of buckets tracked by the store.
+var rateLimitStats = expvar.NewMap("ratelimit")
+
+type rateLimit struct {
+	limiters map[string]*ratelimit.Limiter
+}
+
+// NewRateLimit creates a rate limiting middleware. rates is
+// keyed by mux route name, routes without a name or without
+// an entry in rates are not limited.
+func NewRateLimit(store ratelimit.Store, rates map[string]ratelimit.Rate) *rateLimit {
+	limiters := make(map[string]*ratelimit.Limiter, len(rates))
+	for name, rate := range rates {
+		limiters[name] = ratelimit.New(name, rate, store)
+	}
+
+	if s, ok := store.(interface{ Len() int }); ok {
+		rateLimitStats.Set("buckets", expvar.Func(func() interface{} {
+			return s.Len()
+		}))
+	}
+
+	return &rateLimit{limiters: limiters}
+}
+
+// Middleware function, which will be called for each request
+func (mw *rateLimit) Middleware(next http.Handler) http.Handler {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *rateLimit) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn needs the user middleware to run first, otherwise
+// every request will be limited by IP.
+func (mw *rateLimit) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next(w, r)
+			return
+		}
+
+		l, ok := mw.limiters[route.GetName()]
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		res, err := l.Allow(rateLimitKey(r))
+		if err != nil {
+			// Failing open, an unavailable store should
+			// not take the whole site down.
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.Rate().Burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+
+		if !res.Allowed {
+			rateLimitStats.Add(l.Name()+".limited", 1)
+			secs := int(math.Ceil(res.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			msg := fmt.Sprintf("Too many requests. Please wait %s before trying again.", ratelimit.Humanize(res.RetryAfter))
+			http.Error(w, msg, http.StatusTooManyRequests)
+			return
+		}
+
+		rateLimitStats.Add(l.Name()+".allowed", 1)
+		next(w, r)
+	}
+}
+
+// rateLimitKey limits signed in users by ID, so users
+// sharing an IP don't limit each other, and everybody
+// else by IP.
+func rateLimitKey(r *http.Request) string {
+	if user := context.User(r.Context()); user != nil {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+
+	return "ip:" + lib.ClientIP(r)
+}
@@ -0,0 +1,139 @@
+package middleware_test
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/middleware"
+	"soramon0/webapp/models"
+	"soramon0/webapp/ratelimit"
+
+	"github.com/gorilla/mux"
+)
+
+// testingRateLimit returns a router limiting the "limited"
+// route to one request a minute. The X-User header stands in
+// for the user middleware.
+func testingRateLimit(name string) *mux.Router {
+	rl := middleware.NewRateLimit(ratelimit.NewMemoryStore(), map[string]ratelimit.Rate{
+		name: {Burst: 1, Period: time.Minute},
+	})
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r := mux.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id := r.Header.Get("X-User"); id != "" {
+				n, _ := strconv.Atoi(id)
+				user := &models.User{}
+				user.ID = uint(n)
+				r = r.WithContext(context.WithUser(r.Context(), user))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Use(rl.Middleware)
+	r.HandleFunc("/limited", ok).Name(name)
+	r.HandleFunc("/unlimited", ok).Name(name + "_unlimited")
+	r.HandleFunc("/unnamed", ok)
+	return r
+}
+
+func rateLimitRequest(h http.Handler, path, ip, user string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, nil)
+	r.RemoteAddr = ip + ":1234"
+	if user != "" {
+		r.Header.Set("X-User", user)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	h := testingRateLimit("test_keys")
+
+	steps := []struct {
+		ip, user string
+		want     int
+	}{
+		{"192.0.2.1", "", http.StatusOK},
+		{"192.0.2.1", "", http.StatusTooManyRequests},
+		// Another IP has its own bucket.
+		{"192.0.2.2", "", http.StatusOK},
+		// Signed in users are limited by ID, whatever their IP.
+		{"192.0.2.1", "1", http.StatusOK},
+		{"192.0.2.1", "2", http.StatusOK},
+		{"192.0.2.3", "1", http.StatusTooManyRequests},
+	}
+	for i, s := range steps {
+		if w := rateLimitRequest(h, "/limited", s.ip, s.user); w.Code != s.want {
+			t.Errorf("step %d (ip %s, user %q): status %d, want %d", i, s.ip, s.user, w.Code, s.want)
+		}
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	h := testingRateLimit("test_retry")
+
+	w := rateLimitRequest(h, "/limited", "192.0.2.1", "")
+	if got := w.Header().Get("X-RateLimit-Limit"); got != "1" {
+		t.Errorf("X-RateLimit-Limit = %q, want 1", got)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+
+	w = rateLimitRequest(h, "/limited", "192.0.2.1", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	// One token a minute, the next one is about a minute away.
+	secs, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || secs < 59 || secs > 60 {
+		t.Errorf("Retry-After = %q, want 60", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitPassThrough(t *testing.T) {
+	h := testingRateLimit("test_pass")
+
+	for _, path := range []string{"/unlimited", "/unnamed"} {
+		for i := 0; i < 3; i++ {
+			w := rateLimitRequest(h, path, "192.0.2.1", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s request %d: status %d, want %d", path, i, w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("X-RateLimit-Limit"); got != "" {
+				t.Errorf("%s: X-RateLimit-Limit = %q, want none", path, got)
+			}
+		}
+	}
+}
+
+func TestRateLimitStats(t *testing.T) {
+	h := testingRateLimit("test_stats")
+	for i := 0; i < 3; i++ {
+		rateLimitRequest(h, "/limited", "192.0.2.1", "")
+	}
+
+	stats := expvar.Get("ratelimit").(*expvar.Map)
+	counts := map[string]string{
+		"test_stats.allowed": "1",
+		"test_stats.limited": "2",
+	}
+	for key, want := range counts {
+		v := stats.Get(key)
+		if v == nil || v.String() != want {
+			t.Errorf("ratelimit.%s = %v, want %s", key, v, want)
+		}
+	}
+	if stats.Get("buckets") == nil {
+		t.Error("ratelimit.buckets is not published")
+	}
+}
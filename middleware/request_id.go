@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+)
+
+// RequestIDHeader carries the request ID, it is set on
+// every response.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID matches the IDs accepted from a proxy in
+// front of the app, anything else is replaced.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestID struct{}
+
+// NewRequestID gives every request an ID, reusing the one of
+// the proxy in front of the app if there is one.
+func NewRequestID() *requestID {
+	return &requestID{}
+}
+
+// Middleware function, which will be called for each request
+func (mw *requestID) Middleware(next http.Handler) http.Handler {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *requestID) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *requestID) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			b, err := lib.Bytes(8)
+			if err != nil {
+				http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next(w, r.WithContext(context.WithRequestID(r.Context(), id)))
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+
+	"soramon0/webapp/context"
+)
+
+type requireRole struct {
+	requireUser
+	role string
+}
+
+// RequireRole needs the user middleware
+// otherwise it will not work correctly.
+//
+// Signed in users without role, or a more privileged one,
+// get a 403 Forbidden.
+func NewRequireRole(ru requireUser, role string) *requireRole {
+	return &requireRole{requireUser: ru, role: role}
+}
+
+// Middleware needs the user middleware
+// otherwise it will not work correctly.
+func (mw *requireRole) Middleware(next http.Handler) http.Handler {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// Apply needs the user middleware
+// otherwise it will not work correctly.
+func (mw *requireRole) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn needs the user middleware
+// otherwise it will not work correctly.
+func (mw *requireRole) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return mw.requireUser.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if !user.HasRole(mw.role) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	})
+}
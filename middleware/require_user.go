@@ -2,17 +2,23 @@ package middleware
 
 import (
 	"net/http"
+	"time"
 
 	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
 	"soramon0/webapp/models"
+	"soramon0/webapp/policy"
 )
 
 type user struct {
 	models.UserService
+	hmac lib.HMAC
 }
 
-func NewUser(us models.UserService) *user {
-	return &user{us}
+// NewUser uses hmac to verify the impersonation cookie,
+// see lib.Impersonation.
+func NewUser(us models.UserService, hmac lib.HMAC) *user {
+	return &user{UserService: us, hmac: hmac}
 }
 
 // Middleware function, which will be called for each request
@@ -32,13 +38,17 @@ func (mw *user) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		user, err := mw.ByRemember(cookie.Value)
-		if err != nil {
+		user, err := mw.ByRemember(r.Context(), cookie.Value)
+		if err != nil || user.IsDisabled() {
 			next(w, r)
 			return
 		}
 
 		ctx := r.Context()
+		if target := mw.impersonated(r, user); target != nil {
+			ctx = context.WithImpersonator(ctx, user)
+			user = target
+		}
 		ctx = context.WithUser(ctx, user)
 		r = r.WithContext(ctx)
 
@@ -46,6 +56,27 @@ func (mw *user) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// impersonated returns the user the admin is acting as,
+// or nil if there is no valid impersonation cookie.
+func (mw *user) impersonated(r *http.Request, admin *models.User) *models.User {
+	cookie, err := r.Cookie(lib.ImpersonationCookie)
+	if err != nil {
+		return nil
+	}
+
+	imp, err := mw.hmac.VerifyImpersonation(cookie.Value, time.Now())
+	if err != nil || imp.AdminID != admin.ID {
+		return nil
+	}
+
+	target, err := mw.ByID(r.Context(), imp.UserID)
+	if err != nil || !policy.Can(admin, policy.Impersonate, target) {
+		return nil
+	}
+
+	return target
+}
+
 type requireUser struct {
 	user
 }
@@ -0,0 +1,99 @@
+package middleware_test
+
+import (
+	stdcontext "context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/lib"
+	"soramon0/webapp/middleware"
+	"soramon0/webapp/models"
+	"soramon0/webapp/utils"
+
+	"github.com/nicholasjackson/env"
+)
+
+func testingUser(t *testing.T, us models.UserService, email, role string) (*models.User, string) {
+	ctx := stdcontext.Background()
+	token, err := lib.RememberToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := models.User{
+		Name:     "Sam Lee",
+		Email:    email,
+		Role:     role,
+		Password: "kq7!Vd2#pLm9",
+		Remember: token,
+	}
+	if err := us.Create(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	return &u, token
+}
+
+func TestRequireUser(t *testing.T) {
+	utils.Must(env.Parse())
+	hmac, err := lib.NewHMACFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := models.NewUserServiceWithDB(models.NewMemoryUserDB(), nil)
+	admin, adminToken := testingUser(t, us, "admin@test.com", models.RoleAdmin)
+	user, userToken := testingUser(t, us, "user@test.com", models.RoleUser)
+
+	impersonate := hmac.SignImpersonation(lib.Impersonation{
+		AdminID:   admin.ID,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		status  int
+		userID  uint
+	}{
+		{"signed out", nil, http.StatusFound, 0},
+		{"invalid token", []*http.Cookie{{Name: "remember_token", Value: "invalid"}}, http.StatusFound, 0},
+		{"signed in", []*http.Cookie{{Name: "remember_token", Value: userToken}}, http.StatusOK, user.ID},
+		{"impersonating", []*http.Cookie{
+			{Name: "remember_token", Value: adminToken},
+			{Name: lib.ImpersonationCookie, Value: impersonate},
+		}, http.StatusOK, user.ID},
+		{"impersonation of another admin", []*http.Cookie{
+			{Name: "remember_token", Value: userToken},
+			{Name: lib.ImpersonationCookie, Value: impersonate},
+		}, http.StatusOK, user.ID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *models.User
+			mw := middleware.NewRequireUser(*middleware.NewUser(us, hmac))
+			h := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+				got = context.User(r.Context())
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/galleries", nil)
+			for _, c := range tt.cookies {
+				r.AddCookie(c)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d. Recieved %d", tt.status, w.Code)
+			}
+			if tt.userID == 0 && got != nil {
+				t.Errorf("Expected no user. Recieved %d", got.ID)
+			}
+			if tt.userID != 0 && (got == nil || got.ID != tt.userID) {
+				t.Errorf("Expected user %d. Recieved %v", tt.userID, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"soramon0/webapp/context"
+	"soramon0/webapp/middleware"
+)
+
+func TestSecurityHeadersNonce(t *testing.T) {
+	sh := middleware.NewSecurityHeaders(middleware.SecurityConfig{
+		ContentSecurityPolicy: "script-src 'nonce-{nonce}'",
+	})
+
+	var nonces []string
+	h := sh.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, context.CSPNonce(r.Context()))
+	})
+
+	var csps []string
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		csps = append(csps, w.Header().Get("Content-Security-Policy"))
+	}
+
+	for i, nonce := range nonces {
+		if nonce == "" {
+			t.Fatalf("request %d has no nonce", i)
+		}
+		if want := "script-src 'nonce-" + nonce + "'"; csps[i] != want {
+			t.Errorf("Content-Security-Policy = %q, want %q", csps[i], want)
+		}
+	}
+	if nonces[0] == nonces[1] {
+		t.Error("Expected a fresh nonce on every request")
+	}
+}
+
+func TestSecurityHeadersHSTS(t *testing.T) {
+	cases := []struct {
+		maxAge int
+		want   string
+	}{
+		{0, ""},
+		{31536000, "max-age=31536000; includeSubDomains"},
+	}
+	for _, c := range cases {
+		sh := middleware.NewSecurityHeaders(middleware.SecurityConfig{HSTSMaxAge: c.maxAge})
+		w := httptest.NewRecorder()
+		sh.ApplyFn(func(w http.ResponseWriter, r *http.Request) {})(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if got := w.Header().Get("Strict-Transport-Security"); got != c.want {
+			t.Errorf("HSTSMaxAge %d: Strict-Transport-Security = %q, want %q", c.maxAge, got, c.want)
+		}
+	}
+}
+
+func TestSecurityHeadersImages(t *testing.T) {
+	sh := middleware.NewSecurityHeaders(middleware.SecurityConfig{
+		ContentSecurityPolicy: "default-src 'self'",
+		FrameOptions:          "DENY",
+	})
+	// The override of the /images/ route.
+	imagesSh := sh.With(func(cfg *middleware.SecurityConfig) {
+		cfg.ContentSecurityPolicy = "default-src 'none'; img-src 'self'; sandbox"
+		cfg.InlineExts = []string{".jpg", ".jpeg", ".png", ".gif"}
+	})
+	h := imagesSh.ApplyFn(func(w http.ResponseWriter, r *http.Request) {})
+
+	cases := map[string]string{
+		"/images/galleries/1/beach.jpg":  "inline",
+		"/images/galleries/1/BEACH.PNG":  "inline",
+		"/images/galleries/1/page.html":  "attachment",
+		"/images/galleries/1/script.svg": "attachment",
+	}
+	for path, want := range cases {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := w.Header().Get("Content-Disposition"); got != want {
+			t.Errorf("%s: Content-Disposition = %q, want %q", path, got, want)
+		}
+		if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "sandbox") {
+			t.Errorf("%s: Content-Security-Policy = %q, want sandbox", path, csp)
+		}
+		if got := w.Header().Get("X-Frame-Options"); got != "DENY" {
+			t.Errorf("%s: X-Frame-Options = %q, want it kept from the base config", path, got)
+		}
+	}
+
+	// The base config is left as is.
+	w := httptest.NewRecorder()
+	sh.ApplyFn(func(w http.ResponseWriter, r *http.Request) {})(w, httptest.NewRequest(http.MethodGet, "/galleries", nil))
+	if got := w.Header().Get("Content-Disposition"); got != "" {
+		t.Errorf("Content-Disposition outside /images/ = %q, want none", got)
+	}
+}
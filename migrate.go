@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"soramon0/webapp/migrations"
+	"soramon0/webapp/models"
+)
+
+// migrate applies, reverts or lists the migrations,
+// see usage.
+func migrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate needs up, down, to or status\n\n%s", usage)
+	}
+
+	s := models.NewServices()
+	defer s.Close()
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		ran, err := m.Up()
+		printMigrations("applied", ran)
+		return err
+	case "down":
+		mig, err := m.Down()
+		if mig != nil {
+			printMigrations("reverted", []migrations.Migration{*mig})
+		}
+		return err
+	case "to":
+		if len(args) != 2 {
+			return fmt.Errorf("migrate to needs a VERSION\n\n%s", usage)
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		ran, err := m.To(version)
+		printMigrations("ran", ran)
+		return err
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unknown migrate command %q\n\n%s", args[0], usage)
+}
+
+func printMigrations(verb string, ran []migrations.Migration) {
+	if len(ran) == 0 {
+		fmt.Println("nothing to migrate")
+		return
+	}
+	for _, mig := range ran {
+		fmt.Printf("%s %d_%s\n", verb, mig.Version, mig.Name)
+	}
+}
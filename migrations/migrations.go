@@ -0,0 +1,354 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// files are the migrations of each driver, in the directory
+// of its name.
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// lockKey identifies the advisory lock held while migrating, so
+// instances starting together apply the migrations only once.
+const lockKey = 72160315
+
+// dialect is the SQL of the migrator specific to a database.
+type dialect struct {
+	// lock and unlock take the migrations lock, they are
+	// empty if the database needs none.
+	lock      string
+	unlock    string
+	timestamp string
+}
+
+var dialects = map[string]dialect{
+	"postgres": {
+		lock:      "SELECT pg_advisory_lock($1)",
+		unlock:    "SELECT pg_advisory_unlock($1)",
+		timestamp: "timestamptz",
+	},
+	// SQLite is embedded in a single instance, which holds
+	// its only connection while migrating.
+	"sqlite": {
+		timestamp: "datetime",
+	},
+}
+
+// ErrUnknownVersion is returned when migrating to a version
+// that has no migration.
+var ErrUnknownVersion = errors.New("migrations: unknown version")
+
+// ErrUnknownDriver is returned for a database driver that has
+// no migrations.
+var ErrUnknownDriver = errors.New("migrations: unknown database driver")
+
+// Migration is a versioned change of the schema. Up applies
+// it and Down reverts it.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// fileName matches the migration files, e.g.
+// 0002_add_trash.up.sql.
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations of fsys, sorted by version. Every
+// migration needs both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migrations: invalid version in %s", e.Name())
+		}
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migrations: version %d is used by %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(b)
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migrations: %d_%s needs an up and a down file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Status is a migration and when it was applied, AppliedAt
+// is nil for pending migrations.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies migrations to a database. The applied
+// versions are recorded in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+// New returns a migrator of the migrations embedded in the
+// binary for driver, postgres or sqlite.
+func New(db *sql.DB, driver string) (*Migrator, error) {
+	if _, ok := dialects[driver]; !ok {
+		return nil, ErrUnknownDriver
+	}
+	sub, err := fs.Sub(files, driver)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWithMigrations(db, driver, migrations)
+}
+
+// NewWithMigrations returns a migrator of migrations, which
+// must be sorted by version.
+func NewWithMigrations(db *sql.DB, driver string, migrations []Migration) (*Migrator, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, ErrUnknownDriver
+	}
+
+	return &Migrator{db: db, dialect: d, migrations: migrations}, nil
+}
+
+// Latest returns the version of the last migration, 0 if
+// there are none.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies all the pending migrations and returns them.
+func (m *Migrator) Up() ([]Migration, error) {
+	return m.To(m.Latest())
+}
+
+// Down reverts the last applied migration and returns it,
+// nil if no migration is applied.
+func (m *Migrator) Down() (*Migration, error) {
+	var reverted *Migration
+	err := m.locked(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; ok {
+				reverted = &mig
+				return m.run(conn, mig, false)
+			}
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+// To applies or reverts migrations until version is the last
+// applied one, and returns the migrations it ran. Version 0
+// reverts them all.
+func (m *Migrator) To(version int64) ([]Migration, error) {
+	if version != 0 && !m.known(version) {
+		return nil, ErrUnknownVersion
+	}
+
+	var ran []Migration
+	err := m.locked(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		// Newer migrations are reverted first, last to first.
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; ok && mig.Version > version {
+				if err := m.run(conn, mig, false); err != nil {
+					return err
+				}
+				ran = append(ran, mig)
+			}
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; !ok && mig.Version <= version {
+				if err := m.run(conn, mig, true); err != nil {
+					return err
+				}
+				ran = append(ran, mig)
+			}
+		}
+		return nil
+	})
+
+	return ran, err
+}
+
+// Status returns every migration and when it was applied.
+func (m *Migrator) Status() ([]Status, error) {
+	var statuses []Status
+	err := m.locked(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		statuses = make([]Status, len(m.migrations))
+		for i, mig := range m.migrations {
+			statuses[i] = Status{Migration: mig}
+			if t, ok := applied[mig.Version]; ok {
+				statuses[i].AppliedAt = &t
+			}
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+func (m *Migrator) known(version int64) bool {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// locked runs fn on a connection holding the migrations
+// advisory lock. Advisory locks belong to a session, so
+// everything must run on that same connection.
+func (m *Migrator) locked(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect.lock != "" {
+		if _, err := conn.ExecContext(ctx, m.dialect.lock, lockKey); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, m.dialect.unlock, lockKey)
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at `+m.dialect.timestamp+` NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// applied returns the applied versions and when they were.
+func (m *Migrator) applied(conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var t time.Time
+		if err := rows.Scan(&version, &t); err != nil {
+			return nil, err
+		}
+		applied[version] = t
+	}
+
+	return applied, rows.Err()
+}
+
+// run applies, or reverts if up is false, mig in a transaction
+// along with its schema_migrations row.
+func (m *Migrator) run(conn *sql.Conn, mig Migration, up bool) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script := mig.Up
+	if !up {
+		script = mig.Down
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migrations: %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+			mig.Version, mig.Name, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", mig.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_trash.up.sql":        {Data: []byte("up 2")},
+		"0002_add_trash.down.sql":      {Data: []byte("down 2")},
+		"0001_initial_schema.up.sql":   {Data: []byte("up 1")},
+		"0001_initial_schema.down.sql": {Data: []byte("down 1")},
+		"README.md":                    {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "initial_schema", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "add_trash", Up: "up 2", Down: "down 2"},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("Load returned %d migrations, want %d", len(migrations), len(want))
+	}
+	for i := range want {
+		if migrations[i] != want[i] {
+			t.Errorf("migrations[%d] = %+v, want %+v", i, migrations[i], want[i])
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"missing down": {
+			"0001_a.up.sql": {Data: []byte("up")},
+		},
+		"duplicate version": {
+			"0001_a.up.sql":   {Data: []byte("up")},
+			"0001_a.down.sql": {Data: []byte("down")},
+			"0001_b.up.sql":   {Data: []byte("up")},
+			"0001_b.down.sql": {Data: []byte("down")},
+		},
+		"version 0": {
+			"0000_a.up.sql":   {Data: []byte("up")},
+			"0000_a.down.sql": {Data: []byte("down")},
+		},
+	}
+
+	for name, fsys := range cases {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: Load = nil error", name)
+		}
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	var latest int64
+	for driver := range dialects {
+		m, err := New(nil, driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Latest() == 0 {
+			t.Fatalf("no embedded %s migrations", driver)
+		}
+		// Every driver has the same migrations.
+		if latest != 0 && m.Latest() != latest {
+			t.Errorf("%s migrations end at %d, want %d", driver, m.Latest(), latest)
+		}
+		latest = m.Latest()
+	}
+
+	if _, err := New(nil, "mysql"); err != ErrUnknownDriver {
+		t.Errorf("New(mysql) = %v, want ErrUnknownDriver", err)
+	}
+}
@@ -0,0 +1,105 @@
+-- The schema as created by gorm AutoMigrate before migrations,
+-- existing databases adopt it as is.
+CREATE TABLE IF NOT EXISTS "users" (
+  "id" bigserial,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  "deleted_at" timestamptz,
+  "name" text,
+  "email" text NOT NULL UNIQUE,
+  "role" text NOT NULL DEFAULT 'user',
+  "password_hash" text NOT NULL,
+  "remember_hash" text NOT NULL UNIQUE,
+  "magic_link_hash" text,
+  "magic_link_expires_at" timestamptz,
+  "failed_logins" bigint NOT NULL DEFAULT 0,
+  "locked_until" timestamptz,
+  "totp_secret" text,
+  "totp_enabled" boolean NOT NULL DEFAULT false,
+  "totp_last_step" bigint,
+  "backup_codes" text,
+  "delete_after" timestamptz,
+  "disabled_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_users_delete_after" ON "users" ("delete_after");
+CREATE INDEX IF NOT EXISTS "idx_users_magic_link_hash" ON "users" ("magic_link_hash");
+CREATE INDEX IF NOT EXISTS "idx_users_remember_hash" ON "users" ("remember_hash");
+CREATE INDEX IF NOT EXISTS "idx_users_email" ON "users" ("email");
+CREATE INDEX IF NOT EXISTS "idx_users_deleted_at" ON "users" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "galleries" (
+  "id" bigserial,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  "deleted_at" timestamptz,
+  "user_id" bigint NOT NULL,
+  "title" text NOT NULL,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_galleries_deleted_at" ON "galleries" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_galleries_user_id" ON "galleries" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "credentials" (
+  "id" bigserial,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  "deleted_at" timestamptz,
+  "user_id" bigint NOT NULL,
+  "name" text NOT NULL,
+  "credential_id" text NOT NULL,
+  "public_key" bytea NOT NULL,
+  "sign_count" bigint,
+  "last_used_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_credentials_credential_id" ON "credentials" ("credential_id");
+CREATE INDEX IF NOT EXISTS "idx_credentials_user_id" ON "credentials" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_credentials_deleted_at" ON "credentials" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "identities" (
+  "id" bigserial,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  "deleted_at" timestamptz,
+  "user_id" bigint NOT NULL,
+  "provider" text NOT NULL,
+  "subject" text NOT NULL,
+  "email" text,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_identities_deleted_at" ON "identities" ("deleted_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_identities_provider_subject" ON "identities" ("provider", "subject");
+CREATE INDEX IF NOT EXISTS "idx_identities_user_id" ON "identities" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "exports" (
+  "id" bigserial,
+  "created_at" timestamptz,
+  "updated_at" timestamptz,
+  "deleted_at" timestamptz,
+  "user_id" bigint NOT NULL,
+  "status" text NOT NULL,
+  "filename" text,
+  "expires_at" timestamptz,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_exports_expires_at" ON "exports" ("expires_at");
+CREATE INDEX IF NOT EXISTS "idx_exports_user_id" ON "exports" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_exports_deleted_at" ON "exports" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "audit_events" (
+  "id" bigserial,
+  "created_at" timestamptz,
+  "actor_id" bigint,
+  "action" text NOT NULL,
+  "target_type" text NOT NULL,
+  "target_id" bigint,
+  "ip" text,
+  "request_id" text,
+  "diff" text,
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_audit_target" ON "audit_events" ("target_type", "target_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_action" ON "audit_events" ("action");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_actor_id" ON "audit_events" ("actor_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_created_at" ON "audit_events" ("created_at");
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS "audit_events";
+DROP TABLE IF EXISTS "exports";
+DROP TABLE IF EXISTS "identities";
+DROP TABLE IF EXISTS "credentials";
+DROP TABLE IF EXISTS "galleries";
+DROP TABLE IF EXISTS "users";
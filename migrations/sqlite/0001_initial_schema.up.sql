@@ -0,0 +1,99 @@
+-- The schema of migrations/postgres/0001_initial_schema in
+-- SQLite types. Times are datetime so the driver scans them.
+CREATE TABLE "users" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "created_at" datetime,
+  "updated_at" datetime,
+  "deleted_at" datetime,
+  "name" text,
+  "email" text NOT NULL UNIQUE,
+  "role" text NOT NULL DEFAULT 'user',
+  "password_hash" text NOT NULL,
+  "remember_hash" text NOT NULL UNIQUE,
+  "magic_link_hash" text,
+  "magic_link_expires_at" datetime,
+  "failed_logins" integer NOT NULL DEFAULT 0,
+  "locked_until" datetime,
+  "totp_secret" text,
+  "totp_enabled" boolean NOT NULL DEFAULT false,
+  "totp_last_step" integer,
+  "backup_codes" text,
+  "delete_after" datetime,
+  "disabled_at" datetime
+);
+CREATE INDEX "idx_users_delete_after" ON "users" ("delete_after");
+CREATE INDEX "idx_users_magic_link_hash" ON "users" ("magic_link_hash");
+CREATE INDEX "idx_users_remember_hash" ON "users" ("remember_hash");
+CREATE INDEX "idx_users_email" ON "users" ("email");
+CREATE INDEX "idx_users_deleted_at" ON "users" ("deleted_at");
+
+CREATE TABLE "galleries" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "created_at" datetime,
+  "updated_at" datetime,
+  "deleted_at" datetime,
+  "user_id" integer NOT NULL,
+  "title" text NOT NULL
+);
+CREATE INDEX "idx_galleries_deleted_at" ON "galleries" ("deleted_at");
+CREATE INDEX "idx_galleries_user_id" ON "galleries" ("user_id");
+
+CREATE TABLE "credentials" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "created_at" datetime,
+  "updated_at" datetime,
+  "deleted_at" datetime,
+  "user_id" integer NOT NULL,
+  "name" text NOT NULL,
+  "credential_id" text NOT NULL,
+  "public_key" blob NOT NULL,
+  "sign_count" integer,
+  "last_used_at" datetime
+);
+CREATE UNIQUE INDEX "idx_credentials_credential_id" ON "credentials" ("credential_id");
+CREATE INDEX "idx_credentials_user_id" ON "credentials" ("user_id");
+CREATE INDEX "idx_credentials_deleted_at" ON "credentials" ("deleted_at");
+
+CREATE TABLE "identities" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "created_at" datetime,
+  "updated_at" datetime,
+  "deleted_at" datetime,
+  "user_id" integer NOT NULL,
+  "provider" text NOT NULL,
+  "subject" text NOT NULL,
+  "email" text
+);
+CREATE INDEX "idx_identities_deleted_at" ON "identities" ("deleted_at");
+CREATE UNIQUE INDEX "idx_identities_provider_subject" ON "identities" ("provider", "subject");
+CREATE INDEX "idx_identities_user_id" ON "identities" ("user_id");
+
+CREATE TABLE "exports" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "created_at" datetime,
+  "updated_at" datetime,
+  "deleted_at" datetime,
+  "user_id" integer NOT NULL,
+  "status" text NOT NULL,
+  "filename" text,
+  "expires_at" datetime
+);
+CREATE INDEX "idx_exports_expires_at" ON "exports" ("expires_at");
+CREATE INDEX "idx_exports_user_id" ON "exports" ("user_id");
+CREATE INDEX "idx_exports_deleted_at" ON "exports" ("deleted_at");
+
+CREATE TABLE "audit_events" (
+  "id" integer PRIMARY KEY AUTOINCREMENT,
+  "created_at" datetime,
+  "actor_id" integer,
+  "action" text NOT NULL,
+  "target_type" text NOT NULL,
+  "target_id" integer,
+  "ip" text,
+  "request_id" text,
+  "diff" text
+);
+CREATE INDEX "idx_audit_target" ON "audit_events" ("target_type", "target_id");
+CREATE INDEX "idx_audit_events_action" ON "audit_events" ("action");
+CREATE INDEX "idx_audit_events_actor_id" ON "audit_events" ("actor_id");
+CREATE INDEX "idx_audit_events_created_at" ON "audit_events" ("created_at");
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audit actions.
+const (
+	AuditUserCreate          = "user.create"
+	AuditUserLogin           = "user.login"
+	AuditUserLoginFailed     = "user.login_failed"
+	AuditUserPassword        = "user.password_change"
+	AuditUserDisable         = "user.disable"
+	AuditUserEnable          = "user.enable"
+	AuditUserPasswordReset   = "user.password_reset"
+	AuditUserRole            = "user.role_change"
+	AuditUserImpersonate     = "user.impersonate"
+	AuditUserImpersonateStop = "user.impersonate_stop"
+	AuditGalleryCreate       = "gallery.create"
+	AuditGalleryUpdate       = "gallery.update"
+	AuditGalleryDelete       = "gallery.delete"
+	AuditGalleryRestore      = "gallery.restore"
+	AuditImageUpload         = "image.upload"
+	AuditImageDelete         = "image.delete"
+)
+
+// AuditActions lists every audit action, to filter the log.
+var AuditActions = []string{
+	AuditUserCreate,
+	AuditUserLogin,
+	AuditUserLoginFailed,
+	AuditUserPassword,
+	AuditUserDisable,
+	AuditUserEnable,
+	AuditUserPasswordReset,
+	AuditUserRole,
+	AuditUserImpersonate,
+	AuditUserImpersonateStop,
+	AuditGalleryCreate,
+	AuditGalleryUpdate,
+	AuditGalleryDelete,
+	AuditGalleryRestore,
+	AuditImageUpload,
+	AuditImageDelete,
+}
+
+// AuditEvent is an entry of the audit log. Events are only
+// ever appended, they have no update or delete.
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	// ActorID is the user who did it, 0 for visitors
+	// and background jobs.
+	ActorID    uint   `gorm:"index"`
+	Action     string `gorm:"not null;index"`
+	TargetType string `gorm:"not null;index:idx_audit_target"`
+	// TargetID is 0 when the target is unknown, e.g. a
+	// failed login with an unknown email.
+	TargetID  uint `gorm:"index:idx_audit_target"`
+	IP        string
+	RequestID string
+	// Diff is a JSON object of the changed fields, each one
+	// a {"from": ..., "to": ...} object.
+	Diff string `gorm:"type:text"`
+}
+
+// Actor is who is doing something through the services,
+// stamped on the audit events they record.
+type Actor struct {
+	UserID    uint
+	IP        string
+	RequestID string
+}
+
+// Event returns an event of the actor about the target.
+func (a Actor) Event(action, targetType string, targetID uint) *AuditEvent {
+	return &AuditEvent{
+		ActorID:    a.UserID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         a.IP,
+		RequestID:  a.RequestID,
+	}
+}
+
+// AuditFilter narrows a search of the audit log, its zero
+// fields match everything.
+type AuditFilter struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+}
+
+type AuditDB interface {
+	// Record appends e to the audit log.
+	Record(ctx context.Context, e *AuditEvent) error
+	// Search returns a page of the events matching f, newest
+	// first, and the total number of matching events.
+	Search(ctx context.Context, f AuditFilter, limit, offset int) ([]AuditEvent, int64, error)
+}
+
+type AuditService interface {
+	AuditDB
+}
+
+func NewAuditService(db *gorm.DB) AuditService {
+	return &auditService{
+		AuditDB: &auditGorm{db: db},
+	}
+}
+
+type auditService struct {
+	AuditDB
+}
+
+var _ AuditDB = &auditGorm{}
+
+type auditGorm struct {
+	db *gorm.DB
+}
+
+func (ag *auditGorm) Record(ctx context.Context, e *AuditEvent) error {
+	return ag.db.WithContext(ctx).Create(e).Error
+}
+
+func (ag *auditGorm) Search(ctx context.Context, f AuditFilter, limit, offset int) ([]AuditEvent, int64, error) {
+	db := ag.db.WithContext(ctx).Model(&AuditEvent{})
+	if f.ActorID != 0 {
+		db = db.Where("actor_id = ?", f.ActorID)
+	}
+	if f.Action != "" {
+		db = db.Where("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		db = db.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != 0 {
+		db = db.Where("target_id = ?", f.TargetID)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []AuditEvent
+	err := db.Order("id desc").Limit(limit).Offset(offset).Find(&events).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// auditor records the events of a service on behalf of actor.
+// Nothing is recorded when as is nil.
+type auditor struct {
+	as    AuditDB
+	actor Actor
+}
+
+// record appends an event of the actor about the target,
+// diff is the changed fields as built by auditDiff.
+func (a auditor) record(ctx context.Context, action, targetType string, targetID uint, diff map[string]change) error {
+	if a.as == nil {
+		return nil
+	}
+
+	e := a.actor.Event(action, targetType, targetID)
+	if len(diff) > 0 {
+		b, err := json.Marshal(diff)
+		if err != nil {
+			return err
+		}
+		e.Diff = string(b)
+	}
+
+	return a.as.Record(ctx, e)
+}
+
+// change is a changed field in the diff of an AuditEvent.
+type change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// auditDiff returns the fields whose value differs between
+// from and to. A nil map stands for a created or deleted
+// target, every field of the other one is then changed.
+func auditDiff(from, to map[string]interface{}) map[string]change {
+	diff := map[string]change{}
+	for k, v := range to {
+		if old, ok := from[k]; !ok || !reflect.DeepEqual(old, v) {
+			diff[k] = change{From: from[k], To: v}
+		}
+	}
+	for k, v := range from {
+		if _, ok := to[k]; !ok {
+			diff[k] = change{From: v}
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,83 @@
+package models_test
+
+import (
+	"context"
+	"testing"
+
+	"soramon0/webapp/models"
+)
+
+func TestAuditLog(t *testing.T) {
+	testingServices(t, testAuditLog)
+}
+
+func testAuditLog(t *testing.T, s *models.Services) {
+	ctx := context.Background()
+	actor := models.Actor{IP: "203.0.113.7", RequestID: "req-1"}
+	user := models.User{
+		Name:     "Sam Lee",
+		Email:    "sam@test.com",
+		Password: "kq7!Vd2#pLm9",
+	}
+	if err := s.User.As(actor).Create(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.User.As(actor).Authenticate(ctx, user.Email, "wrong password"); err != models.ErrPasswordInccorect {
+		t.Fatalf("Authenticate err = %v, want ErrPasswordInccorect", err)
+	}
+	// The login itself is recorded once the session starts.
+	u, err := s.User.As(actor).Authenticate(ctx, user.Email, "kq7!Vd2#pLm9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.User.As(actor).RecordLogin(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+
+	actor.UserID = user.ID
+	gallery := models.Gallery{UserID: user.ID, Title: "Holidays"}
+	if err := s.Gallery.As(actor).Create(ctx, &gallery); err != nil {
+		t.Fatal(err)
+	}
+	gallery.Title = "Summer holidays"
+	if err := s.Gallery.As(actor).Update(ctx, &gallery); err != nil {
+		t.Fatal(err)
+	}
+
+	events, total, err := s.Audit.Search(ctx, models.AuditFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		models.AuditGalleryUpdate,
+		models.AuditGalleryCreate,
+		models.AuditUserLogin,
+		models.AuditUserLoginFailed,
+		models.AuditUserCreate,
+	}
+	if int(total) != len(want) {
+		t.Fatalf("total = %d, want %d", total, len(want))
+	}
+	for i, action := range want {
+		if events[i].Action != action {
+			t.Errorf("events[%d].Action = %q, want %q", i, events[i].Action, action)
+		}
+	}
+
+	update := events[0]
+	if update.ActorID != user.ID || update.IP != actor.IP || update.RequestID != actor.RequestID {
+		t.Errorf("update event = %+v, want the actor %+v", update, actor)
+	}
+	if update.Diff != `{"title":{"from":"Holidays","to":"Summer holidays"}}` {
+		t.Errorf("update Diff = %s", update.Diff)
+	}
+
+	events, _, err = s.Audit.Search(ctx, models.AuditFilter{Action: models.AuditUserLoginFailed, TargetID: user.ID}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("failed logins = %d, want 1", len(events))
+	}
+}
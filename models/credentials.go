@@ -0,0 +1,205 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Credential is a WebAuthn public key credential (passkey)
+// registered by a user to sign in without a password.
+type Credential struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index"`
+	Name   string `gorm:"not null"`
+	// CredentialID is the base64 URL encoded ID the
+	// authenticator generated for this credential.
+	CredentialID string `gorm:"not null;uniqueIndex"`
+	// PublicKey is COSE encoded.
+	PublicKey  []byte `gorm:"not null"`
+	SignCount  uint32
+	LastUsedAt *time.Time
+}
+
+type CredentialDB interface {
+	ByID(ctx context.Context, id uint) (*Credential, error)
+	ByCredentialID(ctx context.Context, credentialID string) (*Credential, error)
+	ByUserID(ctx context.Context, userID uint) ([]Credential, error)
+	Create(ctx context.Context, credential *Credential) error
+	Update(ctx context.Context, credential *Credential) error
+	Delete(ctx context.Context, id uint) error
+}
+
+type CredentialService interface {
+	CredentialDB
+}
+
+type credentialService struct {
+	CredentialDB
+}
+
+func NewCredentialService(db *gorm.DB) CredentialService {
+	cg := newCredentialGorm(db)
+	cv := newCredentialValidator(cg)
+
+	return &credentialService{
+		CredentialDB: cv,
+	}
+}
+
+// withDB returns the service storing the credentials in cg.
+func (cs *credentialService) withDB(cg *credentialGorm) *credentialService {
+	c := *cs
+	c.CredentialDB = newCredentialValidator(cg)
+	return &c
+}
+
+type credentialValidator struct {
+	CredentialDB
+}
+
+func newCredentialValidator(cg *credentialGorm) *credentialValidator {
+	return &credentialValidator{
+		CredentialDB: cg,
+	}
+}
+
+func (cv *credentialValidator) Create(ctx context.Context, c *Credential) error {
+	fns := []credentialValidatorFunc{
+		cv.userIDRequired,
+		cv.nameDefault,
+		cv.credentialIDRequired,
+		cv.publicKeyRequired,
+	}
+	if err := runCredentialValFuncs(c, fns...); err != nil {
+		return err
+	}
+	return cv.CredentialDB.Create(ctx, c)
+}
+
+func (cv *credentialValidator) Update(ctx context.Context, c *Credential) error {
+	fns := []credentialValidatorFunc{
+		cv.userIDRequired,
+		cv.nameDefault,
+		cv.credentialIDRequired,
+		cv.publicKeyRequired,
+	}
+	if err := runCredentialValFuncs(c, fns...); err != nil {
+		return err
+	}
+	return cv.CredentialDB.Update(ctx, c)
+}
+
+func (cv *credentialValidator) Delete(ctx context.Context, id uint) error {
+	c := Credential{Model: gorm.Model{ID: id}}
+
+	if err := runCredentialValFuncs(&c, cv.isGreaterThan(0)); err != nil {
+		return err
+	}
+
+	return cv.CredentialDB.Delete(ctx, id)
+}
+
+func (cv *credentialValidator) userIDRequired(c *Credential) error {
+	if c.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (cv *credentialValidator) nameDefault(c *Credential) error {
+	if c.Name == "" {
+		c.Name = "Passkey"
+	}
+
+	return nil
+}
+
+func (cv *credentialValidator) credentialIDRequired(c *Credential) error {
+	if c.CredentialID == "" {
+		return ErrCredentialIDRequired
+	}
+
+	return nil
+}
+
+func (cv *credentialValidator) publicKeyRequired(c *Credential) error {
+	if len(c.PublicKey) == 0 {
+		return ErrPublicKeyRequired
+	}
+
+	return nil
+}
+
+func (cv *credentialValidator) isGreaterThan(n uint) credentialValidatorFunc {
+	return func(c *Credential) error {
+		if c.ID <= n {
+			return ErrIDInvalid
+		}
+
+		return nil
+	}
+}
+
+type credentialValidatorFunc func(*Credential) error
+
+// runCredentialValFuncs runs the given fns passing credential to each one.
+// If it encountres an error, it returns it and breaks.
+func runCredentialValFuncs(c *Credential, fns ...credentialValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type credentialGorm struct {
+	db *gorm.DB
+}
+
+func newCredentialGorm(db *gorm.DB) *credentialGorm {
+	return &credentialGorm{db: db}
+}
+
+func (cg *credentialGorm) ByID(ctx context.Context, id uint) (*Credential, error) {
+	var c Credential
+	db := cg.db.WithContext(ctx).Where("id = ?", id)
+	err := first(db, &c)
+	return &c, err
+}
+
+func (cg *credentialGorm) ByCredentialID(ctx context.Context, credentialID string) (*Credential, error) {
+	var c Credential
+	db := cg.db.WithContext(ctx).Where("credential_id = ?", credentialID)
+	err := first(db, &c)
+	return &c, err
+}
+
+func (cg *credentialGorm) ByUserID(ctx context.Context, userID uint) ([]Credential, error) {
+	var credentials []Credential
+	err := cg.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// Create will create the provided credential and backfill data
+// Like the ID, CreatedAt, and UpdatedAt fields.
+func (cg *credentialGorm) Create(ctx context.Context, c *Credential) error {
+	return cg.db.WithContext(ctx).Create(c).Error
+}
+
+func (cg *credentialGorm) Update(ctx context.Context, c *Credential) error {
+	return cg.db.WithContext(ctx).Save(c).Error
+}
+
+// Delete permanently deletes the credential, a soft deleted
+// row would keep its credential ID taken.
+func (cg *credentialGorm) Delete(ctx context.Context, id uint) error {
+	c := Credential{Model: gorm.Model{ID: id}}
+	return cg.db.WithContext(ctx).Unscoped().Delete(&c).Error
+}
@@ -1,6 +1,10 @@
 package models
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 const (
 	ErrNotFound          = modelError("models: resource not found")
@@ -10,13 +14,27 @@ const (
 	ErrPasswordInccorect = modelError("models: incorrect password provided")
 	ErrPasswordRequired  = modelError("models: password is required")
 	ErrPasswordTooShort  = modelError("models: password must be at least 8 characters long")
+	ErrPasswordTooWeak   = modelError("models: password is too easy to guess, try a longer one or mix in numbers and symbols")
+	ErrPasswordPersonal  = modelError("models: password must not contain your email address or name")
+	ErrPasswordBreached  = modelError("models: password has appeared in a data breach, please choose another one")
 	ErrTitleRequired     = modelError("models: title is required")
+	ErrPasswordNotSet    = modelError("models: this account has no password, please sign in with your identity provider, a passkey or an email link")
+	ErrAccountDisabled   = modelError("models: this account has been disabled, please contact us if you think this is a mistake")
+	ErrMagicLinkInvalid  = modelError("models: this login link is invalid or has expired")
+	ErrTOTPInvalid       = modelError("models: invalid authentication code")
+	ErrTOTPEnabled       = modelError("models: two-factor authentication is already enabled")
 
-	ErrNotImplemented   = privateError("models: not implemented")
-	ErrRememberTooShort = privateError("models: remember token is too short")
-	ErrIDInvalid        = privateError("models: ID provided was invalid")
-	ErrRememberRequired = privateError("models: remember hash is required")
-	ErrUserIDRequired   = privateError("models: user ID is required")
+	ErrNotImplemented       = privateError("models: not implemented")
+	ErrRememberTooShort     = privateError("models: remember token is too short")
+	ErrIDInvalid            = privateError("models: ID provided was invalid")
+	ErrRememberRequired     = privateError("models: remember hash is required")
+	ErrRememberTaken        = privateError("models: remember hash is already taken")
+	ErrUserIDRequired       = privateError("models: user ID is required")
+	ErrCredentialIDRequired = privateError("models: credential ID is required")
+	ErrPublicKeyRequired    = privateError("models: public key is required")
+	ErrSubjectRequired      = privateError("models: identity provider and subject are required")
+	ErrRoleInvalid          = privateError("models: role is not valid")
+	ErrTOTPNotEnrolled      = privateError("models: two-factor authentication enrollment was not started")
 )
 
 type modelError string
@@ -34,3 +52,21 @@ type privateError string
 func (e privateError) Error() string {
 	return string(e)
 }
+
+// LockedError is returned by Authenticate and VerifyPassword
+// while an account is locked after too many failed login attempts.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e LockedError) Error() string {
+	return "models: account is temporarily locked"
+}
+
+func (e LockedError) Public() string {
+	mins := int((e.RetryAfter + time.Minute - 1) / time.Minute)
+	if mins <= 1 {
+		return "Too many failed login attempts. Your account is locked, please try again in 1 minute."
+	}
+	return fmt.Sprintf("Too many failed login attempts. Your account is locked, please try again in %d minutes.", mins)
+}
@@ -0,0 +1,534 @@
+package models
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"soramon0/webapp/lib"
+	"soramon0/webapp/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+
+	// exportDir is not served, exports are downloaded
+	// through the controller which checks the owner.
+	exportDir = "exports"
+)
+
+// Export is an archive of everything we hold about a user:
+// their profile, galleries and images.
+type Export struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index"`
+	Status string `gorm:"not null"`
+	// Filename is the name of the archive in the exports
+	// directory. It is set once the export is ready.
+	Filename  string
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+// Downloadable reports whether the archive is ready and
+// not expired at time t.
+func (e *Export) Downloadable(t time.Time) bool {
+	return e.Status == ExportReady && e.ExpiresAt != nil && e.ExpiresAt.After(t)
+}
+
+func (e *Export) path() string {
+	return filepath.Join(exportDir, e.Filename)
+}
+
+type ExportDB interface {
+	ByID(ctx context.Context, id uint) (*Export, error)
+	ByUserID(ctx context.Context, userID uint) ([]Export, error)
+	// Expired returns the exports whose download link
+	// expired before t, and the failed exports without one.
+	Expired(ctx context.Context, t time.Time) ([]Export, error)
+	// PendingBefore returns the exports still pending that
+	// were requested before t.
+	PendingBefore(ctx context.Context, t time.Time) ([]Export, error)
+	Create(ctx context.Context, export *Export) error
+	Update(ctx context.Context, export *Export) error
+	Delete(ctx context.Context, id uint) error
+}
+
+type ExportService interface {
+	ExportDB
+	// Run builds the archive of the export and marks it ready
+	// or failed. Either way it expires utils.GetExportTTL()
+	// from now.
+	Run(ctx context.Context, e *Export) error
+	// Open opens the archive of a ready export.
+	Open(ctx context.Context, e *Export) (io.ReadCloser, error)
+	// PurgeExpired deletes the expired exports and their
+	// archives. It returns the number of deleted exports.
+	PurgeExpired(ctx context.Context, t time.Time) (int, error)
+	// FailStale marks the exports requested before t and still
+	// pending as failed, the process running them died. It
+	// returns the number of failed exports.
+	FailStale(ctx context.Context, t time.Time) (int, error)
+}
+
+type exportService struct {
+	ExportDB
+	us  UserService
+	gs  GalleryService
+	is  ImageService
+	cs  CredentialService
+	ids IdentityService
+	// files stages the archive removals, nil removes them
+	// right away.
+	files *fileStage
+}
+
+func NewExportService(db *gorm.DB, us UserService, gs GalleryService, is ImageService, cs CredentialService, ids IdentityService) ExportService {
+	eg := newExportGorm(db)
+	ev := newExportValidator(eg)
+
+	return &exportService{
+		ExportDB: ev,
+		us:       us,
+		gs:       gs,
+		is:       is,
+		cs:       cs,
+		ids:      ids,
+	}
+}
+
+// staged returns the service storing the exports in eg and
+// staging its archive removals in files.
+func (es *exportService) staged(eg *exportGorm, files *fileStage) *exportService {
+	c := *es
+	c.ExportDB = newExportValidator(eg)
+	c.files = files
+	return &c
+}
+
+func (es *exportService) Run(ctx context.Context, e *Export) error {
+	err := es.build(ctx, e)
+	if err != nil {
+		e.Status = ExportFailed
+	} else {
+		e.Status = ExportReady
+	}
+	// Failed exports are listed until they expire too, so the
+	// user can tell what happened.
+	expires := time.Now().UTC().Add(utils.GetExportTTL())
+	e.ExpiresAt = &expires
+
+	// The status is saved even if ctx was cancelled, a pending
+	// export would prevent the user from starting a new one.
+	if uerr := es.Update(context.Background(), e); err == nil {
+		err = uerr
+	}
+
+	return err
+}
+
+func (es *exportService) Open(ctx context.Context, e *Export) (io.ReadCloser, error) {
+	if e.Status != ExportReady {
+		return nil, ErrNotFound
+	}
+
+	return os.Open(e.path())
+}
+
+// Delete deletes the archive of the export, if any, and
+// then the export itself.
+func (es *exportService) Delete(ctx context.Context, id uint) error {
+	e, err := es.ByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if e.Filename != "" {
+		if err := es.files.remove(e.path()); err != nil {
+			return err
+		}
+	}
+
+	return es.ExportDB.Delete(ctx, id)
+}
+
+func (es *exportService) PurgeExpired(ctx context.Context, t time.Time) (int, error) {
+	exports, err := es.Expired(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, e := range exports {
+		if err := es.Delete(ctx, e.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(exports), nil
+}
+
+func (es *exportService) FailStale(ctx context.Context, t time.Time) (int, error) {
+	exports, err := es.PendingBefore(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+
+	expires := time.Now().UTC().Add(utils.GetExportTTL())
+	for i := range exports {
+		e := &exports[i]
+		e.Status = ExportFailed
+		e.ExpiresAt = &expires
+		if err := es.Update(ctx, e); err != nil {
+			return i, err
+		}
+	}
+
+	return len(exports), nil
+}
+
+// exportData is the JSON document of the archive.
+type exportData struct {
+	ExportedAt time.Time        `json:"exported_at"`
+	User       exportUser       `json:"user"`
+	Galleries  []exportGallery  `json:"galleries"`
+	Passkeys   []exportPasskey  `json:"passkeys"`
+	Identities []exportIdentity `json:"identities"`
+}
+
+type exportUser struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	HasPassword bool      `json:"has_password"`
+	TOTPEnabled bool      `json:"two_factor_enabled"`
+}
+
+type exportGallery struct {
+	ID        uint          `json:"id"`
+	Title     string        `json:"title"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Images    []exportImage `json:"images"`
+}
+
+type exportImage struct {
+	Filename string `json:"filename"`
+	// File is the path of the image in the archive.
+	File string `json:"file"`
+	Size int64  `json:"size"`
+}
+
+type exportPasskey struct {
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type exportIdentity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// build writes the archive to a temporary file, renamed
+// once it is complete.
+func (es *exportService) build(ctx context.Context, e *Export) error {
+	if err := os.MkdirAll(exportDir, 0700); err != nil {
+		return err
+	}
+
+	token, err := lib.RememberToken()
+	if err != nil {
+		return err
+	}
+	filename := fmt.Sprintf("%d-%s.zip", e.ID, token)
+
+	f, err := os.CreateTemp(exportDir, "tmp-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := es.write(ctx, f, e.UserID); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), filepath.Join(exportDir, filename)); err != nil {
+		return err
+	}
+
+	e.Filename = filename
+	return nil
+}
+
+// write writes the zip archive of the user to w: the original
+// images and a data.json document describing everything else.
+func (es *exportService) write(ctx context.Context, w io.Writer, userID uint) error {
+	u, err := es.us.ByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	data := exportData{
+		ExportedAt: time.Now().UTC(),
+		User: exportUser{
+			ID:          u.ID,
+			Name:        u.Name,
+			Email:       u.Email,
+			CreatedAt:   u.CreatedAt,
+			UpdatedAt:   u.UpdatedAt,
+			HasPassword: u.PasswordHash != "",
+			TOTPEnabled: u.TOTPEnabled,
+		},
+		Galleries:  []exportGallery{},
+		Passkeys:   []exportPasskey{},
+		Identities: []exportIdentity{},
+	}
+
+	zw := zip.NewWriter(w)
+
+	galleries, err := es.gs.ByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, g := range galleries {
+		eg := exportGallery{
+			ID:        g.ID,
+			Title:     g.Title,
+			CreatedAt: g.CreatedAt,
+			UpdatedAt: g.UpdatedAt,
+			Images:    []exportImage{},
+		}
+
+		images, err := es.is.ByGalleryID(ctx, g.ID)
+		if err != nil {
+			return err
+		}
+		for _, img := range images {
+			// Copying the images is the slow part, it stops
+			// when the job is cancelled.
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			name := fmt.Sprintf("galleries/%d/%s", g.ID, img.Filename)
+			n, err := es.writeImage(ctx, zw, name, &img)
+			if err != nil {
+				return err
+			}
+			eg.Images = append(eg.Images, exportImage{
+				Filename: img.Filename,
+				File:     name,
+				Size:     n,
+			})
+		}
+
+		data.Galleries = append(data.Galleries, eg)
+	}
+
+	credentials, err := es.cs.ByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, c := range credentials {
+		data.Passkeys = append(data.Passkeys, exportPasskey{
+			Name:       c.Name,
+			CreatedAt:  c.CreatedAt,
+			LastUsedAt: c.LastUsedAt,
+		})
+	}
+
+	identities, err := es.ids.ByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, i := range identities {
+		data.Identities = append(data.Identities, exportIdentity{
+			Provider:  i.Provider,
+			Email:     i.Email,
+			CreatedAt: i.CreatedAt,
+		})
+	}
+
+	jw, err := zw.Create("data.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(jw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func (es *exportService) writeImage(ctx context.Context, zw *zip.Writer, name string, img *Image) (int64, error) {
+	r, err := es.is.Open(ctx, img)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	// Images are already compressed.
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return 0, err
+	}
+
+	return io.Copy(fw, r)
+}
+
+type exportValidator struct {
+	ExportDB
+}
+
+func newExportValidator(eg *exportGorm) *exportValidator {
+	return &exportValidator{
+		ExportDB: eg,
+	}
+}
+
+func (ev *exportValidator) Create(ctx context.Context, e *Export) error {
+	fns := []exportValidatorFunc{ev.userIDRequired, ev.statusDefault}
+	if err := runExportValFuncs(e, fns...); err != nil {
+		return err
+	}
+	return ev.ExportDB.Create(ctx, e)
+}
+
+func (ev *exportValidator) Update(ctx context.Context, e *Export) error {
+	fns := []exportValidatorFunc{ev.userIDRequired, ev.statusDefault}
+	if err := runExportValFuncs(e, fns...); err != nil {
+		return err
+	}
+	return ev.ExportDB.Update(ctx, e)
+}
+
+func (ev *exportValidator) Delete(ctx context.Context, id uint) error {
+	e := Export{Model: gorm.Model{ID: id}}
+
+	if err := runExportValFuncs(&e, ev.isGreaterThan(0)); err != nil {
+		return err
+	}
+
+	return ev.ExportDB.Delete(ctx, id)
+}
+
+func (ev *exportValidator) userIDRequired(e *Export) error {
+	if e.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (ev *exportValidator) statusDefault(e *Export) error {
+	if e.Status == "" {
+		e.Status = ExportPending
+	}
+
+	return nil
+}
+
+func (ev *exportValidator) isGreaterThan(n uint) exportValidatorFunc {
+	return func(e *Export) error {
+		if e.ID <= n {
+			return ErrIDInvalid
+		}
+
+		return nil
+	}
+}
+
+type exportValidatorFunc func(*Export) error
+
+// runExportValFuncs runs the given fns passing export to each one.
+// If it encountres an error, it returns it and breaks.
+func runExportValFuncs(e *Export, fns ...exportValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type exportGorm struct {
+	db *gorm.DB
+}
+
+func newExportGorm(db *gorm.DB) *exportGorm {
+	return &exportGorm{db: db}
+}
+
+func (eg *exportGorm) ByID(ctx context.Context, id uint) (*Export, error) {
+	var e Export
+	db := eg.db.WithContext(ctx).Where("id = ?", id)
+	err := first(db, &e)
+	return &e, err
+}
+
+func (eg *exportGorm) ByUserID(ctx context.Context, userID uint) ([]Export, error) {
+	var exports []Export
+	err := eg.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at desc").Find(&exports).Error
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+func (eg *exportGorm) Expired(ctx context.Context, t time.Time) ([]Export, error) {
+	var exports []Export
+	// In UTC like expires_at, SQLite compares times as text.
+	// Exports failed before their expiry was recorded have none.
+	err := eg.db.WithContext(ctx).
+		Where("expires_at < ?", t.UTC()).
+		Or("status = ? AND expires_at IS NULL", ExportFailed).
+		Find(&exports).Error
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+func (eg *exportGorm) PendingBefore(ctx context.Context, t time.Time) ([]Export, error) {
+	var exports []Export
+	err := eg.db.WithContext(ctx).
+		Where("status = ? AND created_at < ?", ExportPending, t.UTC()).
+		Find(&exports).Error
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// Create will create the provided export and backfill data
+// Like the ID, CreatedAt, and UpdatedAt fields.
+func (eg *exportGorm) Create(ctx context.Context, e *Export) error {
+	return eg.db.WithContext(ctx).Create(e).Error
+}
+
+func (eg *exportGorm) Update(ctx context.Context, e *Export) error {
+	return eg.db.WithContext(ctx).Save(e).Error
+}
+
+// Delete permanently deletes the export, its archive
+// is gone too.
+func (eg *exportGorm) Delete(ctx context.Context, id uint) error {
+	e := Export{Model: gorm.Model{ID: id}}
+	return eg.db.WithContext(ctx).Unscoped().Delete(&e).Error
+}
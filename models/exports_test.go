@@ -0,0 +1,47 @@
+package models_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"soramon0/webapp/models"
+	"soramon0/webapp/utils"
+)
+
+func TestExportFailStale(t *testing.T) {
+	testingServices(t, func(t *testing.T, s *models.Services) {
+		ctx := context.Background()
+		e := models.Export{UserID: 1}
+		if err := s.Export.Create(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := s.Export.FailStale(ctx, time.Now().Add(-time.Minute))
+		if err != nil || n != 0 {
+			t.Fatalf("FailStale = %d, %v; want 0, nil for a recent export", n, err)
+		}
+
+		now := time.Now().Add(time.Minute)
+		n, err = s.Export.FailStale(ctx, now)
+		if err != nil || n != 1 {
+			t.Fatalf("FailStale = %d, %v; want 1, nil", n, err)
+		}
+
+		got, err := s.Export.ByID(ctx, e.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != models.ExportFailed || got.ExpiresAt == nil {
+			t.Fatalf("Expected a failed export with an expiry. Recieved %s, %v", got.Status, got.ExpiresAt)
+		}
+
+		n, err = s.Export.PurgeExpired(ctx, now.Add(utils.GetExportTTL()))
+		if err != nil || n != 1 {
+			t.Fatalf("PurgeExpired = %d, %v; want 1, nil", n, err)
+		}
+		if _, err := s.Export.ByID(ctx, e.ID); err != models.ErrNotFound {
+			t.Errorf("Expected failed export to be purged. Recieved %v", err)
+		}
+	})
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"context"
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -25,27 +28,124 @@ func (g *Gallery) ImageSplitN(n int) [][]Image {
 }
 
 type GalleryDB interface {
-	ByID(id uint) (*Gallery, error)
-	ByUserID(id uint) ([]Gallery, error)
-	Create(gallery *Gallery) error
-	Update(gallery *Gallery) error
-	Delete(id uint) error
+	ByID(ctx context.Context, id uint) (*Gallery, error)
+	ByUserID(ctx context.Context, id uint) ([]Gallery, error)
+	// List returns a page of the galleries of the user, or of
+	// everyone if userID is 0, newest first, and the total
+	// number of galleries.
+	List(ctx context.Context, userID uint, limit, offset int) ([]Gallery, int64, error)
+	Create(ctx context.Context, gallery *Gallery) error
+	Update(ctx context.Context, gallery *Gallery) error
+	// Delete moves the gallery to the trash, it can be
+	// restored until it is purged.
+	Delete(ctx context.Context, id uint) error
+	// DeletedByID returns the gallery in the trash.
+	DeletedByID(ctx context.Context, id uint) (*Gallery, error)
+	// DeletedByUserID returns the galleries of the user in the
+	// trash, most recently deleted first.
+	DeletedByUserID(ctx context.Context, userID uint) ([]Gallery, error)
+	// DeletedBefore returns the galleries moved to the trash
+	// before t.
+	DeletedBefore(ctx context.Context, t time.Time) ([]Gallery, error)
+	// Restore takes the gallery out of the trash.
+	Restore(ctx context.Context, id uint) error
+	// Purge permanently deletes the gallery row.
+	Purge(ctx context.Context, id uint) error
 }
 
 type GalleryService interface {
+	// As returns the service acting on behalf of a, who is
+	// recorded in the audit log.
+	As(a Actor) GalleryService
 	GalleryDB
 }
 
 type galleryService struct {
 	GalleryDB
+	auditor
+}
+
+func NewGalleryService(db *gorm.DB, as AuditDB) GalleryService {
+	return NewGalleryServiceWithDB(newGalleryGorm(db), as)
 }
 
-func NewGalleryService(db *gorm.DB) GalleryService {
-	gg := newGalleryGorm(db)
-	gv := newGalleryValidator(gg)
+// NewGalleryServiceWithDB returns a GalleryService validating
+// and storing the galleries in gdb, e.g. NewMemoryGalleryDB in
+// tests.
+func NewGalleryServiceWithDB(gdb GalleryDB, as AuditDB) GalleryService {
+	gv := newGalleryValidator(gdb)
 
 	return &galleryService{
 		GalleryDB: gv,
+		auditor:   auditor{as: as},
+	}
+}
+
+// withDB returns the service storing the galleries in gdb,
+// and recording its changes in as.
+func (gs *galleryService) withDB(gdb GalleryDB, as AuditDB) *galleryService {
+	c := *gs
+	c.GalleryDB = newGalleryValidator(gdb)
+	c.as = as
+	return &c
+}
+
+func (gs *galleryService) As(a Actor) GalleryService {
+	c := *gs
+	c.actor = a
+	return &c
+}
+
+func (gs *galleryService) Create(ctx context.Context, g *Gallery) error {
+	if err := gs.GalleryDB.Create(ctx, g); err != nil {
+		return err
+	}
+
+	return gs.record(ctx, AuditGalleryCreate, "gallery", g.ID, auditDiff(nil, g.auditFields()))
+}
+
+func (gs *galleryService) Update(ctx context.Context, g *Gallery) error {
+	old, err := gs.ByID(ctx, g.ID)
+	if err != nil {
+		return err
+	}
+	if err := gs.GalleryDB.Update(ctx, g); err != nil {
+		return err
+	}
+
+	return gs.record(ctx, AuditGalleryUpdate, "gallery", g.ID, auditDiff(old.auditFields(), g.auditFields()))
+}
+
+func (gs *galleryService) Delete(ctx context.Context, id uint) error {
+	old, err := gs.ByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := gs.GalleryDB.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	return gs.record(ctx, AuditGalleryDelete, "gallery", id, auditDiff(old.auditFields(), nil))
+}
+
+func (gs *galleryService) Restore(ctx context.Context, id uint) error {
+	g, err := gs.DeletedByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := gs.GalleryDB.Restore(ctx, id); err != nil {
+		return err
+	}
+
+	return gs.record(ctx, AuditGalleryRestore, "gallery", id, auditDiff(nil, g.auditFields()))
+}
+
+// auditFields are the fields of the gallery shown in the
+// audit log.
+func (g *Gallery) auditFields() map[string]interface{} {
+	return map[string]interface{}{
+		"title":   g.Title,
+		"user_id": g.UserID,
 	}
 }
 
@@ -53,36 +153,56 @@ type galleryValidator struct {
 	GalleryDB
 }
 
-func newGalleryValidator(gg *galleryGorm) *galleryValidator {
+func newGalleryValidator(gdb GalleryDB) *galleryValidator {
 	return &galleryValidator{
-		GalleryDB: gg,
+		GalleryDB: gdb,
 	}
 }
 
-func (gv *galleryValidator) Create(g *Gallery) error {
+func (gv *galleryValidator) Create(ctx context.Context, g *Gallery) error {
 	fns := []galleryValidatorFunc{gv.userIDRequired, gv.titleRequired}
 	if err := runGalleryValFuncs(g, fns...); err != nil {
 		return err
 	}
-	return gv.GalleryDB.Create(g)
+	return gv.GalleryDB.Create(ctx, g)
 }
 
-func (gv *galleryValidator) Update(g *Gallery) error {
+func (gv *galleryValidator) Update(ctx context.Context, g *Gallery) error {
 	fns := []galleryValidatorFunc{gv.userIDRequired, gv.titleRequired}
 	if err := runGalleryValFuncs(g, fns...); err != nil {
 		return err
 	}
-	return gv.GalleryDB.Update(g)
+	return gv.GalleryDB.Update(ctx, g)
+}
+
+func (gv *galleryValidator) Delete(ctx context.Context, id uint) error {
+	g := Gallery{Model: gorm.Model{ID: id}}
+
+	if err := runGalleryValFuncs(&g, gv.isGreaterThan(0)); err != nil {
+		return err
+	}
+
+	return gv.GalleryDB.Delete(ctx, id)
+}
+
+func (gv *galleryValidator) Restore(ctx context.Context, id uint) error {
+	g := Gallery{Model: gorm.Model{ID: id}}
+
+	if err := runGalleryValFuncs(&g, gv.isGreaterThan(0)); err != nil {
+		return err
+	}
+
+	return gv.GalleryDB.Restore(ctx, id)
 }
 
-func (gv *galleryValidator) Delete(id uint) error {
+func (gv *galleryValidator) Purge(ctx context.Context, id uint) error {
 	g := Gallery{Model: gorm.Model{ID: id}}
 
 	if err := runGalleryValFuncs(&g, gv.isGreaterThan(0)); err != nil {
 		return err
 	}
 
-	return gv.GalleryDB.Delete(id)
+	return gv.GalleryDB.Purge(ctx, id)
 }
 
 func (gv *galleryValidator) userIDRequired(g *Gallery) error {
@@ -132,33 +252,90 @@ func newGalleryGorm(db *gorm.DB) *galleryGorm {
 	return &galleryGorm{db: db}
 }
 
-func (gg *galleryGorm) ByID(id uint) (*Gallery, error) {
+func (gg *galleryGorm) ByID(ctx context.Context, id uint) (*Gallery, error) {
 	var g Gallery
-	db := gg.db.Where("id = ?", id)
+	db := gg.db.WithContext(ctx).Where("id = ?", id)
 	err := first(db, &g)
 	return &g, err
 }
 
-func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
+func (gg *galleryGorm) ByUserID(ctx context.Context, userID uint) ([]Gallery, error) {
 	var galleries []Gallery
-	err := gg.db.Where("user_id = ?", userID).Find(&galleries).Error
+	err := gg.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&galleries).Error
 	if err != nil {
 		return nil, err
 	}
 	return galleries, nil
 }
 
+func (gg *galleryGorm) List(ctx context.Context, userID uint, limit, offset int) ([]Gallery, int64, error) {
+	db := gg.db.WithContext(ctx).Model(&Gallery{})
+	if userID != 0 {
+		db = db.Where("user_id = ?", userID)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var galleries []Gallery
+	err := db.Order("id desc").Limit(limit).Offset(offset).Find(&galleries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return galleries, total, nil
+}
+
 // Create will create the provided gallery and backfill data
 // Like the ID, CreatedAt, and UpdatedAt fields.
-func (gg *galleryGorm) Create(g *Gallery) error {
-	return gg.db.Create(g).Error
+func (gg *galleryGorm) Create(ctx context.Context, g *Gallery) error {
+	return gg.db.WithContext(ctx).Create(g).Error
+}
+
+func (gg *galleryGorm) Update(ctx context.Context, g *Gallery) error {
+	return gg.db.WithContext(ctx).Save(g).Error
+}
+
+func (gg *galleryGorm) Delete(ctx context.Context, id uint) error {
+	g := Gallery{Model: gorm.Model{ID: id}}
+	return gg.db.WithContext(ctx).Delete(&g).Error
+}
+
+func (gg *galleryGorm) DeletedByID(ctx context.Context, id uint) (*Gallery, error) {
+	var g Gallery
+	db := gg.db.WithContext(ctx).Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id)
+	err := first(db, &g)
+	return &g, err
+}
+
+func (gg *galleryGorm) DeletedByUserID(ctx context.Context, userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.WithContext(ctx).Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at desc").Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (gg *galleryGorm) DeletedBefore(ctx context.Context, t time.Time) ([]Gallery, error) {
+	var galleries []Gallery
+	// In UTC like deleted_at, SQLite compares times as text.
+	err := gg.db.WithContext(ctx).Unscoped().Where("deleted_at < ?", t.UTC()).Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
 }
 
-func (gg *galleryGorm) Update(g *Gallery) error {
-	return gg.db.Save(g).Error
+func (gg *galleryGorm) Restore(ctx context.Context, id uint) error {
+	return gg.db.WithContext(ctx).Unscoped().Model(&Gallery{}).
+		Where("id = ?", id).Update("deleted_at", nil).Error
 }
 
-func (gg *galleryGorm) Delete(id uint) error {
+func (gg *galleryGorm) Purge(ctx context.Context, id uint) error {
 	g := Gallery{Model: gorm.Model{ID: id}}
-	return gg.db.Delete(&g).Error
+	return gg.db.WithContext(ctx).Unscoped().Delete(&g).Error
 }
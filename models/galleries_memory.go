@@ -0,0 +1,194 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var _ GalleryDB = &galleryMemory{}
+
+// galleryMemory is a GalleryDB keeping the galleries in memory,
+// for tests that don't need a database. It is safe for
+// concurrent use and, like galleryGorm, soft deletes the
+// galleries.
+type galleryMemory struct {
+	mu        sync.RWMutex
+	galleries map[uint]Gallery
+	lastID    uint
+}
+
+// NewMemoryGalleryDB returns an empty in-memory GalleryDB, to
+// wrap with NewGalleryServiceWithDB.
+func NewMemoryGalleryDB() GalleryDB {
+	return &galleryMemory{galleries: map[uint]Gallery{}}
+}
+
+func (gm *galleryMemory) ByID(ctx context.Context, id uint) (*Gallery, error) {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+
+	g, ok := gm.galleries[id]
+	if !ok || g.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	return &g, nil
+}
+
+func (gm *galleryMemory) ByUserID(ctx context.Context, userID uint) ([]Gallery, error) {
+	galleries := gm.filter(userID)
+	// Oldest first, like galleryGorm.
+	for i, j := 0, len(galleries)-1; i < j; i, j = i+1, j-1 {
+		galleries[i], galleries[j] = galleries[j], galleries[i]
+	}
+	return galleries, nil
+}
+
+func (gm *galleryMemory) List(ctx context.Context, userID uint, limit, offset int) ([]Gallery, int64, error) {
+	galleries := gm.filter(userID)
+	total := int64(len(galleries))
+
+	if offset >= len(galleries) {
+		return nil, total, nil
+	}
+	galleries = galleries[offset:]
+	if limit >= 0 && limit < len(galleries) {
+		galleries = galleries[:limit]
+	}
+	return galleries, total, nil
+}
+
+func (gm *galleryMemory) Create(ctx context.Context, g *Gallery) error {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	gm.lastID++
+	now := time.Now()
+	g.ID = gm.lastID
+	g.CreatedAt = now
+	g.UpdatedAt = now
+	gm.galleries[g.ID] = gm.row(g)
+	return nil
+}
+
+func (gm *galleryMemory) Update(ctx context.Context, g *Gallery) error {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	if _, ok := gm.galleries[g.ID]; !ok {
+		return ErrNotFound
+	}
+
+	g.UpdatedAt = time.Now()
+	gm.galleries[g.ID] = gm.row(g)
+	return nil
+}
+
+func (gm *galleryMemory) Delete(ctx context.Context, id uint) error {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	g, ok := gm.galleries[id]
+	if !ok || g.DeletedAt.Valid {
+		return nil
+	}
+
+	g.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	gm.galleries[id] = g
+	return nil
+}
+
+func (gm *galleryMemory) DeletedByID(ctx context.Context, id uint) (*Gallery, error) {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+
+	g, ok := gm.galleries[id]
+	if !ok || !g.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	return &g, nil
+}
+
+func (gm *galleryMemory) DeletedByUserID(ctx context.Context, userID uint) ([]Gallery, error) {
+	galleries := gm.deleted(func(g Gallery) bool {
+		return g.UserID == userID
+	})
+	sort.Slice(galleries, func(i, j int) bool {
+		return galleries[i].DeletedAt.Time.After(galleries[j].DeletedAt.Time)
+	})
+	return galleries, nil
+}
+
+func (gm *galleryMemory) DeletedBefore(ctx context.Context, t time.Time) ([]Gallery, error) {
+	return gm.deleted(func(g Gallery) bool {
+		return g.DeletedAt.Time.Before(t)
+	}), nil
+}
+
+func (gm *galleryMemory) Restore(ctx context.Context, id uint) error {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	g, ok := gm.galleries[id]
+	if !ok {
+		return nil
+	}
+
+	g.DeletedAt = gorm.DeletedAt{}
+	gm.galleries[id] = g
+	return nil
+}
+
+func (gm *galleryMemory) Purge(ctx context.Context, id uint) error {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+
+	delete(gm.galleries, id)
+	return nil
+}
+
+// row returns the stored copy of g, without the images which
+// are not a column.
+func (gm *galleryMemory) row(g *Gallery) Gallery {
+	row := *g
+	row.Images = nil
+	return row
+}
+
+// filter returns copies of the galleries of the user, or of
+// everyone if userID is 0, that are not deleted, newest first.
+func (gm *galleryMemory) filter(userID uint) []Gallery {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+
+	var galleries []Gallery
+	for _, g := range gm.galleries {
+		if !g.DeletedAt.Valid && (userID == 0 || g.UserID == userID) {
+			galleries = append(galleries, g)
+		}
+	}
+	sort.Slice(galleries, func(i, j int) bool {
+		return galleries[i].ID > galleries[j].ID
+	})
+
+	return galleries
+}
+
+// deleted returns copies of the galleries in the trash
+// matching fn.
+func (gm *galleryMemory) deleted(fn func(g Gallery) bool) []Gallery {
+	gm.mu.RLock()
+	defer gm.mu.RUnlock()
+
+	var galleries []Gallery
+	for _, g := range gm.galleries {
+		if g.DeletedAt.Valid && fn(g) {
+			galleries = append(galleries, g)
+		}
+	}
+
+	return galleries
+}
@@ -0,0 +1,191 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"soramon0/webapp/models"
+)
+
+func TestGalleryTrash(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The images are stored relative to the working directory.
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	testingServices(t, testGalleryTrash)
+}
+
+func testGalleryTrash(t *testing.T, s *models.Services) {
+	ctx := context.Background()
+	user := models.User{
+		Name:     "Sam Lee",
+		Email:    "sam@test.com",
+		Password: "kq7!Vd2#pLm9",
+	}
+	if err := s.User.Create(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	gallery := models.Gallery{UserID: user.ID, Title: "Holidays"}
+	if err := s.Gallery.Create(ctx, &gallery); err != nil {
+		t.Fatal(err)
+	}
+	img := ioutil.NopCloser(strings.NewReader("image"))
+	if err := s.Image.Create(ctx, gallery.ID, img, "beach.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	trash := func() {
+		err := s.WithTx(ctx, func(tx *models.Tx) error {
+			if err := tx.Gallery.Delete(ctx, gallery.ID); err != nil {
+				return err
+			}
+			return tx.Image.Trash(ctx, gallery.ID)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	trash()
+	if _, err := s.Gallery.ByID(ctx, gallery.ID); err != models.ErrNotFound {
+		t.Fatalf("ByID of a trashed gallery err = %v, want ErrNotFound", err)
+	}
+	image := models.Image{GalleryID: gallery.ID, Filename: "beach.jpg"}
+	if _, err := os.Stat(image.RelativePath()); !os.IsNotExist(err) {
+		t.Fatalf("the image of a trashed gallery is still served: %v", err)
+	}
+	deleted, err := s.Gallery.DeletedByUserID(ctx, user.ID)
+	if err != nil || len(deleted) != 1 {
+		t.Fatalf("DeletedByUserID = %v, %v, want the trashed gallery", deleted, err)
+	}
+
+	err = s.WithTx(ctx, func(tx *models.Tx) error {
+		if err := tx.Gallery.Restore(ctx, gallery.ID); err != nil {
+			return err
+		}
+		return tx.Image.Restore(ctx, gallery.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Gallery.ByID(ctx, gallery.ID); err != nil {
+		t.Fatalf("ByID of a restored gallery: %v", err)
+	}
+	if images, _ := s.Image.ByGalleryID(ctx, gallery.ID); len(images) != 1 {
+		t.Fatalf("images of the restored gallery = %v, want 1", images)
+	}
+
+	// The images are moved back when the transaction fails
+	// after moving them.
+	errAbort := errors.New("abort")
+	err = s.WithTx(ctx, func(tx *models.Tx) error {
+		if err := tx.Gallery.Delete(ctx, gallery.ID); err != nil {
+			return err
+		}
+		if err := tx.Image.Trash(ctx, gallery.ID); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("WithTx err = %v, want %v", err, errAbort)
+	}
+	if _, err := os.Stat(image.RelativePath()); err != nil {
+		t.Fatalf("the image is not back after the rollback: %v", err)
+	}
+
+	// A failed move aborts the delete, the gallery would be
+	// gone while its images are still served.
+	if err := os.RemoveAll("trash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("trash", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = s.WithTx(ctx, func(tx *models.Tx) error {
+		if err := tx.Gallery.Delete(ctx, gallery.ID); err != nil {
+			return err
+		}
+		return tx.Image.Trash(ctx, gallery.ID)
+	})
+	if err == nil {
+		t.Fatal("Expected the move to the trash to fail")
+	}
+	if _, err := s.Gallery.ByID(ctx, gallery.ID); err != nil {
+		t.Fatalf("ByID after a failed move: %v", err)
+	}
+	if err := os.Remove("trash"); err != nil {
+		t.Fatal(err)
+	}
+
+	trash()
+	if n, err := s.PurgeDeletedGalleries(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("PurgeDeletedGalleries before the retention = %d, %v, want 0", n, err)
+	}
+	if n, err := s.PurgeDeletedGalleries(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("PurgeDeletedGalleries = %d, %v, want 1", n, err)
+	}
+	if _, err := s.Gallery.DeletedByID(ctx, gallery.ID); err != models.ErrNotFound {
+		t.Fatalf("DeletedByID of a purged gallery err = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(fmt.Sprintf("trash/galleries/%d", gallery.ID)); !os.IsNotExist(err) {
+		t.Fatalf("the images of a purged gallery are still on disk: %v", err)
+	}
+}
+
+func TestImageDelete(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The images are stored relative to the working directory.
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	testingServices(t, testImageDelete)
+}
+
+func testImageDelete(t *testing.T, s *models.Services) {
+	ctx := context.Background()
+	img := ioutil.NopCloser(strings.NewReader("image"))
+	if err := s.Image.Create(ctx, 1, img, "beach.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	image := models.Image{GalleryID: 1, Filename: "beach.jpg"}
+	if err := s.Image.Delete(ctx, &image); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting it again, or in a transaction, finds nothing to
+	// delete and records nothing.
+	if err := s.Image.Delete(ctx, &image); err != models.ErrNotFound {
+		t.Errorf("Delete of a missing image err = %v, want ErrNotFound", err)
+	}
+	err := s.WithTx(ctx, func(tx *models.Tx) error {
+		return tx.Image.Delete(ctx, &image)
+	})
+	if err != models.ErrNotFound {
+		t.Errorf("Delete of a missing image in a transaction err = %v, want ErrNotFound", err)
+	}
+
+	events, _, err := s.Audit.Search(ctx, models.AuditFilter{Action: models.AuditImageDelete}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("image delete events = %d, want 1", len(events))
+	}
+}
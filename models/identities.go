@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Identity links a user to an account at an external
+// OpenID Connect provider, so they can sign in with it.
+type Identity struct {
+	gorm.Model
+	UserID uint `gorm:"not null;index"`
+	// Provider is the issuer identifier of the provider,
+	// subjects are only unique per issuer.
+	Provider string `gorm:"not null;uniqueIndex:idx_identities_provider_subject"`
+	Subject  string `gorm:"not null;uniqueIndex:idx_identities_provider_subject"`
+	Email    string
+}
+
+type IdentityDB interface {
+	ByProviderSubject(ctx context.Context, provider, subject string) (*Identity, error)
+	ByUserID(ctx context.Context, userID uint) ([]Identity, error)
+	Create(ctx context.Context, identity *Identity) error
+	Delete(ctx context.Context, id uint) error
+}
+
+type IdentityService interface {
+	IdentityDB
+}
+
+type identityService struct {
+	IdentityDB
+}
+
+func NewIdentityService(db *gorm.DB) IdentityService {
+	ig := newIdentityGorm(db)
+	iv := newIdentityValidator(ig)
+
+	return &identityService{
+		IdentityDB: iv,
+	}
+}
+
+// withDB returns the service storing the identities in ig.
+func (ids *identityService) withDB(ig *identityGorm) *identityService {
+	c := *ids
+	c.IdentityDB = newIdentityValidator(ig)
+	return &c
+}
+
+type identityValidator struct {
+	IdentityDB
+}
+
+func newIdentityValidator(ig *identityGorm) *identityValidator {
+	return &identityValidator{
+		IdentityDB: ig,
+	}
+}
+
+func (iv *identityValidator) Create(ctx context.Context, i *Identity) error {
+	fns := []identityValidatorFunc{
+		iv.userIDRequired,
+		iv.providerSubjectRequired,
+	}
+	if err := runIdentityValFuncs(i, fns...); err != nil {
+		return err
+	}
+	return iv.IdentityDB.Create(ctx, i)
+}
+
+func (iv *identityValidator) Delete(ctx context.Context, id uint) error {
+	i := Identity{Model: gorm.Model{ID: id}}
+
+	if err := runIdentityValFuncs(&i, iv.isGreaterThan(0)); err != nil {
+		return err
+	}
+
+	return iv.IdentityDB.Delete(ctx, id)
+}
+
+func (iv *identityValidator) userIDRequired(i *Identity) error {
+	if i.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (iv *identityValidator) providerSubjectRequired(i *Identity) error {
+	if i.Provider == "" || i.Subject == "" {
+		return ErrSubjectRequired
+	}
+
+	return nil
+}
+
+func (iv *identityValidator) isGreaterThan(n uint) identityValidatorFunc {
+	return func(i *Identity) error {
+		if i.ID <= n {
+			return ErrIDInvalid
+		}
+
+		return nil
+	}
+}
+
+type identityValidatorFunc func(*Identity) error
+
+// runIdentityValFuncs runs the given fns passing identity to each one.
+// If it encountres an error, it returns it and breaks.
+func runIdentityValFuncs(i *Identity, fns ...identityValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type identityGorm struct {
+	db *gorm.DB
+}
+
+func newIdentityGorm(db *gorm.DB) *identityGorm {
+	return &identityGorm{db: db}
+}
+
+func (ig *identityGorm) ByProviderSubject(ctx context.Context, provider, subject string) (*Identity, error) {
+	var i Identity
+	db := ig.db.WithContext(ctx).Where("provider = ? AND subject = ?", provider, subject)
+	err := first(db, &i)
+	return &i, err
+}
+
+func (ig *identityGorm) ByUserID(ctx context.Context, userID uint) ([]Identity, error) {
+	var identities []Identity
+	err := ig.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	if err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// Create will create the provided identity and backfill data
+// Like the ID, CreatedAt, and UpdatedAt fields.
+func (ig *identityGorm) Create(ctx context.Context, i *Identity) error {
+	return ig.db.WithContext(ctx).Create(i).Error
+}
+
+// Delete permanently deletes the identity, so the external
+// account can be linked again.
+func (ig *identityGorm) Delete(ctx context.Context, id uint) error {
+	i := Identity{Model: gorm.Model{ID: id}}
+	return ig.db.WithContext(ctx).Unscoped().Delete(&i).Error
+}
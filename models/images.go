@@ -1,13 +1,24 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+const (
+	// galleriesDir holds a directory of images per gallery.
+	galleriesDir = "images/galleries/"
+	// trashDir holds the images of the deleted galleries. It is
+	// outside of images/, so they are not served.
+	trashDir = "trash/galleries/"
+)
+
 type Image struct {
 	GalleryID uint
 	Filename  string
@@ -22,40 +33,87 @@ func (i *Image) RelativePath() string {
 }
 
 type ImageService interface {
-	Create(galleryID uint, r io.ReadCloser, filename string) error
-	ByGalleryID(galleryID uint) ([]Image, error)
-	Delete(i *Image) error
+	Create(ctx context.Context, galleryID uint, r io.ReadCloser, filename string) error
+	ByGalleryID(ctx context.Context, galleryID uint) ([]Image, error)
+	// Open opens the original image file.
+	Open(ctx context.Context, i *Image) (io.ReadCloser, error)
+	// Delete removes the image, it returns ErrNotFound if
+	// there is none.
+	Delete(ctx context.Context, i *Image) error
+	// DeleteAll deletes every image of the gallery, including
+	// the ones in the trash.
+	DeleteAll(ctx context.Context, galleryID uint) error
+	// Trash moves the images of the deleted gallery to the
+	// trash, they are not served anymore.
+	Trash(ctx context.Context, galleryID uint) error
+	// Restore moves the images of the gallery back from the
+	// trash.
+	Restore(ctx context.Context, galleryID uint) error
+	// GalleryIDs returns the IDs of the galleries that have
+	// an images directory on disk.
+	GalleryIDs(ctx context.Context) ([]uint, error)
+	// Usage returns the disk space used by the images of the
+	// gallery, or of all galleries if galleryID is 0, in bytes.
+	Usage(ctx context.Context, galleryID uint) (int64, error)
+	// As returns the service acting on behalf of a, who is
+	// recorded in the audit log.
+	As(a Actor) ImageService
 }
 
-func NewImageService() ImageService {
-	return &imageService{}
+func NewImageService(as AuditDB) ImageService {
+	return &imageService{
+		auditor: auditor{as: as},
+	}
 }
 
-type imageService struct{}
-
-func (is *imageService) Create(galleryID uint, r io.ReadCloser, filename string) error {
-	defer r.Close()
-
-	path, err := is.mkImagePath(galleryID)
-	if err != nil {
-		return err
+// NewImageServiceWithDir returns an ImageService storing the
+// images and the trash in dir instead of the working
+// directory, e.g. a temporary directory in tests.
+func NewImageServiceWithDir(dir string, as AuditDB) ImageService {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
 	}
 
-	dst, err := os.Create(path + filename)
-	if err != nil {
-		return err
+	return &imageService{
+		auditor: auditor{as: as},
+		dir:     dir,
 	}
-	defer dst.Close()
+}
 
-	_, err = io.Copy(dst, r)
-	if err != nil {
+type imageService struct {
+	auditor
+	files *fileStage
+	// dir is the root of galleriesDir and trashDir, the
+	// working directory if empty.
+	dir string
+}
+
+// staged returns the service staging its file changes in
+// files, and recording them in as.
+func (is *imageService) staged(files *fileStage, as AuditDB) *imageService {
+	c := *is
+	c.files = files
+	c.as = as
+	return &c
+}
+
+func (is *imageService) As(a Actor) ImageService {
+	c := *is
+	c.actor = a
+	return &c
+}
+
+func (is *imageService) Create(ctx context.Context, galleryID uint, r io.ReadCloser, filename string) error {
+	defer r.Close()
+
+	if err := is.files.write(is.imagePath(galleryID)+filename, r); err != nil {
 		return err
 	}
 
-	return nil
+	return is.recordImage(ctx, AuditImageUpload, galleryID, nil, filename)
 }
 
-func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
+func (is *imageService) ByGalleryID(ctx context.Context, galleryID uint) ([]Image, error) {
 	path := is.imagePath(galleryID)
 	imagePaths, err := filepath.Glob(path + "*")
 	if err != nil {
@@ -73,19 +131,89 @@ func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
 	return images, nil
 }
 
-func (is *imageService) Delete(i *Image) error {
-	return os.Remove(i.RelativePath())
+func (is *imageService) Open(ctx context.Context, i *Image) (io.ReadCloser, error) {
+	return os.Open(is.dir + i.RelativePath())
 }
 
-func (is *imageService) mkImagePath(galleryID uint) (string, error) {
-	path := is.imagePath(galleryID)
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return "", err
+func (is *imageService) Delete(ctx context.Context, i *Image) error {
+	if err := is.files.removeFile(is.dir + i.RelativePath()); err != nil {
+		return err
 	}
 
-	return path, nil
+	return is.recordImage(ctx, AuditImageDelete, i.GalleryID, i.Filename, nil)
+}
+
+// recordImage records an image change of the gallery, images
+// have no ID of their own.
+func (is *imageService) recordImage(ctx context.Context, action string, galleryID uint, from, to interface{}) error {
+	diff := map[string]change{"image": {From: from, To: to}}
+	return is.record(ctx, action, "gallery", galleryID, diff)
+}
+
+func (is *imageService) DeleteAll(ctx context.Context, galleryID uint) error {
+	if err := is.files.remove(is.imagePath(galleryID)); err != nil {
+		return err
+	}
+
+	return is.files.remove(is.trashPath(galleryID))
+}
+
+func (is *imageService) Trash(ctx context.Context, galleryID uint) error {
+	return is.files.move(is.imagePath(galleryID), is.trashPath(galleryID))
+}
+
+func (is *imageService) Restore(ctx context.Context, galleryID uint) error {
+	return is.files.move(is.trashPath(galleryID), is.imagePath(galleryID))
+}
+
+func (is *imageService) GalleryIDs(ctx context.Context) ([]uint, error) {
+	dirs, err := ioutil.ReadDir(is.dir + galleriesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	for _, d := range dirs {
+		id, err := strconv.ParseUint(d.Name(), 10, 0)
+		if !d.IsDir() || err != nil {
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+
+	return ids, nil
+}
+
+func (is *imageService) Usage(ctx context.Context, galleryID uint) (int64, error) {
+	root := is.dir + galleriesDir
+	if galleryID != 0 {
+		root = is.imagePath(galleryID)
+	}
+
+	var size int64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	return size, err
 }
 
 func (is *imageService) imagePath(galleryID uint) string {
-	return fmt.Sprintf("images/galleries/%v/", galleryID)
+	return fmt.Sprintf("%s%s%v/", is.dir, galleriesDir, galleryID)
+}
+
+func (is *imageService) trashPath(galleryID uint) string {
+	return fmt.Sprintf("%s%s%v/", is.dir, trashDir, galleryID)
 }
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// PurgeUser permanently deletes the user with the provided ID
+// along with everything they own: galleries and their images,
+// in the trash or not, passkeys, linked identities and data
+// exports. It runs in a transaction, the files are removed
+// once everything else is.
+func (s *Services) PurgeUser(ctx context.Context, id uint) error {
+	return s.WithTx(ctx, func(tx *Tx) error {
+		return tx.purgeUser(ctx, id)
+	})
+}
+
+func (tx *Tx) purgeUser(ctx context.Context, id uint) error {
+	galleries, err := tx.Gallery.ByUserID(ctx, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := tx.Gallery.DeletedByUserID(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, g := range append(galleries, deleted...) {
+		if err := purgeGallery(ctx, tx.Gallery, tx.Image, g.ID); err != nil {
+			return err
+		}
+	}
+
+	credentials, err := tx.Credential.ByUserID(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, c := range credentials {
+		if err := tx.Credential.Delete(ctx, c.ID); err != nil {
+			return err
+		}
+	}
+
+	identities, err := tx.Identity.ByUserID(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, i := range identities {
+		if err := tx.Identity.Delete(ctx, i.ID); err != nil {
+			return err
+		}
+	}
+
+	exports, err := tx.Export.ByUserID(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, e := range exports {
+		if err := tx.Export.Delete(ctx, e.ID); err != nil {
+			return err
+		}
+	}
+
+	return tx.User.Purge(ctx, id)
+}
+
+// PurgeDeletedGalleries purges the galleries moved to the
+// trash before t. It returns the number of purged galleries.
+func (s *Services) PurgeDeletedGalleries(ctx context.Context, t time.Time) (int, error) {
+	galleries, err := s.Gallery.DeletedBefore(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, g := range galleries {
+		if err := purgeGallery(ctx, s.Gallery, s.Image, g.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(galleries), nil
+}
+
+func purgeGallery(ctx context.Context, gs GalleryService, is ImageService, id uint) error {
+	// Images go first, a gallery row is never purged while
+	// its files are still on disk. In a transaction they are
+	// removed once it is committed.
+	if err := is.DeleteAll(ctx, id); err != nil {
+		return err
+	}
+
+	return gs.Purge(ctx, id)
+}
+
+// PurgeDeletedUsers purges the users whose deletion grace
+// period ended before t. It returns the number of purged users.
+func (s *Services) PurgeDeletedUsers(ctx context.Context, t time.Time) (int, error) {
+	users, err := s.User.PendingDeletion(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, u := range users {
+		if err := s.PurgeUser(ctx, u.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(users), nil
+}
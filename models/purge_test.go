@@ -0,0 +1,83 @@
+package models_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"soramon0/webapp/models"
+)
+
+func TestPurgeUser(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The images and exports are stored relative to the
+	// working directory.
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	testingServices(t, testPurgeUser)
+}
+
+func testPurgeUser(t *testing.T, s *models.Services) {
+	ctx := context.Background()
+	user := models.User{
+		Name:     "Sam Lee",
+		Email:    "sam@test.com",
+		Password: "kq7!Vd2#pLm9",
+	}
+	if err := s.User.Create(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	gallery := models.Gallery{UserID: user.ID, Title: "Holidays"}
+	if err := s.Gallery.Create(ctx, &gallery); err != nil {
+		t.Fatal(err)
+	}
+	img := ioutil.NopCloser(strings.NewReader("image"))
+	if err := s.Image.Create(ctx, gallery.ID, img, "beach.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	credential := models.Credential{UserID: user.ID, CredentialID: "credential", PublicKey: []byte("key")}
+	if err := s.Credential.Create(ctx, &credential); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("exports", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("exports/sam.zip", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	export := models.Export{UserID: user.ID, Filename: "sam.zip"}
+	if err := s.Export.Create(ctx, &export); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PurgeUser(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.User.ByID(ctx, user.ID); err != models.ErrNotFound {
+		t.Errorf("ByID of a purged user err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Gallery.ByID(ctx, gallery.ID); err != models.ErrNotFound {
+		t.Errorf("ByID of a purged gallery err = %v, want ErrNotFound", err)
+	}
+	if credentials, _ := s.Credential.ByUserID(ctx, user.ID); len(credentials) != 0 {
+		t.Errorf("%d credentials left after the purge", len(credentials))
+	}
+	if _, err := s.Export.ByID(ctx, export.ID); err != models.ErrNotFound {
+		t.Errorf("ByID of a purged export err = %v, want ErrNotFound", err)
+	}
+	if ids, _ := s.Image.GalleryIDs(ctx); len(ids) != 0 {
+		t.Errorf("the images of a purged user are still on disk: %v", ids)
+	}
+	if _, err := os.Stat("exports/sam.zip"); !os.IsNotExist(err) {
+		t.Errorf("the export of a purged user is still on disk: %v", err)
+	}
+}
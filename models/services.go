@@ -2,42 +2,96 @@ package models
 
 import (
 	"soramon0/webapp/lib"
-	"soramon0/webapp/utils"
+	"soramon0/webapp/migrations"
 
 	"gorm.io/gorm"
 )
 
 type Services struct {
-	Gallery GalleryService
-	Image   ImageService
-	User    UserService
-	db      *gorm.DB
+	Audit      AuditService
+	Credential CredentialService
+	Export     ExportService
+	Gallery    GalleryService
+	Identity   IdentityService
+	Image      ImageService
+	User       UserService
+	db         *gorm.DB
 }
 
 func NewServices() *Services {
-	db := lib.InitDB()
-	us := NewUserService(db)
-	is := NewImageService()
-	gs := NewGalleryService(db)
+	return NewServicesWithDB(lib.InitDB())
+}
+
+// NewServicesWithDB returns the services of db, opened with
+// lib.OpenDB.
+func NewServicesWithDB(db *gorm.DB) *Services {
+	as := NewAuditService(db)
+	us := NewUserService(db, as)
+	is := NewImageService(as)
+	gs := NewGalleryService(db, as)
+	cs := NewCredentialService(db)
+	ids := NewIdentityService(db)
+	es := NewExportService(db, us, gs, is, cs, ids)
 
 	return &Services{
-		db:      db,
-		Image:   is,
-		Gallery: gs,
-		User:    us,
+		db:         db,
+		Audit:      as,
+		Credential: cs,
+		Export:     es,
+		Image:      is,
+		Gallery:    gs,
+		Identity:   ids,
+		User:       us,
 	}
 }
 
-func (s *Services) AutoMigrate() error {
-	return s.db.AutoMigrate(&User{}, &Gallery{})
+// Migrator returns the migrator of the database schema.
+func (s *Services) Migrator() (*migrations.Migrator, error) {
+	db, err := s.db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	return migrations.New(db, s.db.Dialector.Name())
+}
+
+// Migrate applies the pending schema migrations.
+func (s *Services) Migrate() error {
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+
+	_, err = m.Up()
+	return err
 }
 
+// DestructiveReset reverts every migration, dropping all the
+// data, and applies them again.
 func (s *Services) DestructiveReset() error {
-	utils.Must(s.db.Migrator().DropTable(&User{}, &Gallery{}))
-	return s.db.AutoMigrate(&User{}, &Gallery{})
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+
+	// Databases created before migrations adopt the
+	// schema first, so reverting it drops their tables.
+	if _, err := m.Up(); err != nil {
+		return err
+	}
+	if _, err := m.To(0); err != nil {
+		return err
+	}
+	_, err = m.Up()
+	return err
 }
 
-// Close returns a not implemented error
+// Close closes the database connections.
 func (s *Services) Close() error {
-	return ErrNotImplemented
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return db.Close()
 }
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"soramon0/webapp/lib"
+)
+
+const (
+	backupCodeCount = 10
+	backupCodeBytes = 5
+)
+
+// TwoFactor is used to manage the time-based one-time
+// password (TOTP) second factor of users.
+type TwoFactor interface {
+	// EnrollTOTP generates a new TOTP secret for the user and
+	// stores it encrypted. Two-factor authentication stays
+	// disabled until EnableTOTP confirms a valid code, so the
+	// user can't lock themselves out.
+	EnrollTOTP(ctx context.Context, u *User) (secret string, err error)
+	// EnableTOTP enables two-factor authentication if code is
+	// valid for the enrolled secret, and returns freshly
+	// generated backup codes. They are only stored hashed, so
+	// this is the only time they can be shown to the user.
+	EnableTOTP(ctx context.Context, u *User, code string) (backupCodes []string, err error)
+	// DisableTOTP disables two-factor authentication if code
+	// is a valid TOTP or backup code.
+	DisableTOTP(ctx context.Context, u *User, code string) error
+	// VerifyTOTP checks the second factor of a user, either a
+	// TOTP code or one of the unused backup codes. It returns
+	// ErrTOTPInvalid if the code is not valid.
+	VerifyTOTP(ctx context.Context, u *User, code string) error
+}
+
+func (us *userService) EnrollTOTP(ctx context.Context, u *User) (string, error) {
+	if u.TOTPEnabled {
+		return "", ErrTOTPEnabled
+	}
+
+	secret, err := lib.TOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := us.totpCipher.Encrypt(secret)
+	if err != nil {
+		return "", err
+	}
+
+	u.TOTPSecret = encrypted
+	if err := us.Update(ctx, u); err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+func (us *userService) EnableTOTP(ctx context.Context, u *User, code string) ([]string, error) {
+	if u.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+
+	if err := us.checkTOTP(ctx, u, code); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, backupCodeCount)
+	hashes := make([]string, backupCodeCount)
+	for i := range codes {
+		b, err := lib.Bytes(backupCodeBytes)
+		if err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = c[:4] + "-" + c[4:]
+		hashes[i] = us.hmac.Hash(c)
+	}
+
+	u.TOTPEnabled = true
+	u.BackupCodes = strings.Join(hashes, " ")
+	if err := us.Update(ctx, u); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (us *userService) DisableTOTP(ctx context.Context, u *User, code string) error {
+	if err := us.VerifyTOTP(ctx, u, code); err != nil {
+		return err
+	}
+
+	u.TOTPEnabled = false
+	u.TOTPSecret = ""
+	u.TOTPLastStep = 0
+	u.BackupCodes = ""
+	return us.Update(ctx, u)
+}
+
+func (us *userService) VerifyTOTP(ctx context.Context, u *User, code string) error {
+	if !u.TOTPEnabled {
+		return ErrTOTPNotEnrolled
+	}
+
+	err := us.checkTOTP(ctx, u, code)
+	if err == ErrTOTPInvalid {
+		return us.useBackupCode(ctx, u, code)
+	}
+
+	return err
+}
+
+// checkTOTP validates code against the enrolled secret and
+// records the time step so the same code can't be replayed.
+func (us *userService) checkTOTP(ctx context.Context, u *User, code string) error {
+	if u.TOTPSecret == "" {
+		return ErrTOTPNotEnrolled
+	}
+
+	secret, err := us.totpCipher.Decrypt(u.TOTPSecret)
+	if err != nil {
+		return err
+	}
+
+	step, err := lib.ValidateTOTP(secret, code, time.Now())
+	if err != nil {
+		return err
+	}
+	if step < 0 || step <= u.TOTPLastStep {
+		return ErrTOTPInvalid
+	}
+
+	u.TOTPLastStep = step
+	return us.Update(ctx, u)
+}
+
+// useBackupCode removes code from the unused backup codes
+// of the user. It returns ErrTOTPInvalid if there was no
+// such code.
+func (us *userService) useBackupCode(ctx context.Context, u *User, code string) error {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if code == "" {
+		return ErrTOTPInvalid
+	}
+
+	hashes := strings.Fields(u.BackupCodes)
+	for _, hash := range us.hmac.Hashes(code) {
+		for i, h := range hashes {
+			if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+				hashes = append(hashes[:i], hashes[i+1:]...)
+				u.BackupCodes = strings.Join(hashes, " ")
+				return us.Update(ctx, u)
+			}
+		}
+	}
+
+	return ErrTOTPInvalid
+}
@@ -0,0 +1,254 @@
+package models
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// stagingDir holds the images written in a transaction until
+// it is committed. It is outside of images/, so they are not
+// served before.
+const stagingDir = "staging/"
+
+// Tx holds the services bound to a database transaction,
+// see Services.WithTx.
+type Tx struct {
+	User       UserService
+	Gallery    GalleryService
+	Credential CredentialService
+	Identity   IdentityService
+	// Image stages the file changes, they are applied once
+	// the transaction is committed. Images created in the
+	// transaction are not listed by ByGalleryID until then.
+	// Moves are the exception, see fileStage.move.
+	Image ImageService
+	// Export removes the deleted archives once the
+	// transaction is committed.
+	Export ExportService
+}
+
+// Transactor runs units of work spanning several services.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(tx *Tx) error) error
+}
+
+// WithTx runs fn in a database transaction, which is committed
+// if fn returns nil and rolled back otherwise. The files
+// deleted through tx.Image and tx.Export are removed after
+// the commit, the
+// files written are discarded and the files moved are moved
+// back on rollback.
+func (s *Services) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	files := &fileStage{}
+	defer func() {
+		if p := recover(); p != nil {
+			files.rollback()
+			panic(p)
+		}
+	}()
+
+	err := s.db.WithContext(ctx).Transaction(func(db *gorm.DB) error {
+		as := NewAuditService(db)
+		return fn(&Tx{
+			User:       s.User.(*userService).withDB(newUserGorm(db), as),
+			Gallery:    s.Gallery.(*galleryService).withDB(newGalleryGorm(db), as),
+			Credential: s.Credential.(*credentialService).withDB(newCredentialGorm(db)),
+			Identity:   s.Identity.(*identityService).withDB(newIdentityGorm(db)),
+			Image:      s.Image.(*imageService).staged(files, as),
+			Export:     s.Export.(*exportService).staged(newExportGorm(db), files),
+		})
+	})
+	if err != nil {
+		files.rollback()
+		return err
+	}
+
+	return files.commit()
+}
+
+// fileStage holds the file changes of a transaction. A nil
+// *fileStage applies them right away.
+type fileStage struct {
+	mu        sync.Mutex
+	commits   []func() error
+	rollbacks []func() error
+}
+
+func (fs *fileStage) add(commit, rollback func() error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if commit != nil {
+		fs.commits = append(fs.commits, commit)
+	}
+	if rollback != nil {
+		fs.rollbacks = append(fs.rollbacks, rollback)
+	}
+}
+
+// write writes the content of r to path. Staged files are
+// written to stagingDir and moved to path on commit.
+func (fs *fileStage) write(path string, r io.Reader) error {
+	if fs == nil {
+		return writeFile(path, r)
+	}
+
+	tmp, err := writeTemp(r)
+	if err != nil {
+		return err
+	}
+
+	fs.add(func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}, func() error {
+		return os.Remove(tmp)
+	})
+	return nil
+}
+
+// remove removes the file or directory at path, and
+// everything it contains.
+func (fs *fileStage) remove(path string) error {
+	if fs == nil {
+		return os.RemoveAll(path)
+	}
+
+	fs.add(func() error {
+		return os.RemoveAll(path)
+	}, nil)
+	return nil
+}
+
+// removeFile removes the file at path. It returns ErrNotFound
+// if there is none, staged or not.
+func (fs *fileStage) removeFile(path string) error {
+	if fs == nil {
+		return removeFile(path)
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	fs.add(func() error {
+		return removeFile(path)
+	}, nil)
+	return nil
+}
+
+// move renames the file or directory at from to to, if
+// there is one. Staged moves happen right away, so a failed
+// move aborts the transaction instead of leaving it committed
+// without its files, and they are moved back on rollback.
+func (fs *fileStage) move(from, to string) error {
+	if err := moveFile(from, to); err != nil || fs == nil {
+		return err
+	}
+
+	fs.add(nil, func() error {
+		return moveFile(to, from)
+	})
+	return nil
+}
+
+// commit applies the staged changes in order. They all run,
+// the first error is returned.
+func (fs *fileStage) commit() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var first error
+	for _, fn := range fs.commits {
+		if err := fn(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// rollback discards the staged files and moves back the moved
+// ones. It is best effort, the files left behind in stagingDir
+// are never served.
+func (fs *fileStage) rollback() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// In reverse, a file may have been moved more than once.
+	for i := len(fs.rollbacks) - 1; i >= 0; i-- {
+		fs.rollbacks[i]()
+	}
+}
+
+func writeFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, r)
+	return err
+}
+
+func removeFile(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func moveFile(from, to string) error {
+	_, err := os.Stat(from)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Without the trailing slash of directories, filepath.Dir
+	// returns their parent.
+	to = filepath.Clean(to)
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(to); err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Clean(from), to)
+}
+
+func writeTemp(r io.Reader) (string, error) {
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return "", err
+	}
+
+	dst, err := os.CreateTemp(stagingDir, "upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, r); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+
+	return dst.Name(), nil
+}
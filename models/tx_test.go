@@ -0,0 +1,108 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"soramon0/webapp/models"
+)
+
+func TestWithTx(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The images are stored relative to the working directory.
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	testingServices(t, testWithTx)
+}
+
+func testWithTx(t *testing.T, s *models.Services) {
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+	user := models.User{
+		Name:     "Sam Lee",
+		Email:    "sam@test.com",
+		Password: "kq7!Vd2#pLm9",
+	}
+	var gallery models.Gallery
+	create := func(tx *models.Tx) error {
+		if err := tx.User.Create(ctx, &user); err != nil {
+			return err
+		}
+		gallery = models.Gallery{UserID: user.ID, Title: "Holidays"}
+		if err := tx.Gallery.Create(ctx, &gallery); err != nil {
+			return err
+		}
+		img := ioutil.NopCloser(strings.NewReader("image"))
+		return tx.Image.Create(ctx, gallery.ID, img, "beach.jpg")
+	}
+
+	err := s.WithTx(ctx, func(tx *models.Tx) error {
+		if err := create(tx); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("WithTx err = %v, want %v", err, errAbort)
+	}
+	if _, err := s.User.ByEmail(ctx, user.Email); err != models.ErrNotFound {
+		t.Fatalf("ByEmail after rollback err = %v, want ErrNotFound", err)
+	}
+	if ids, _ := s.Image.GalleryIDs(ctx); len(ids) != 0 {
+		t.Fatalf("GalleryIDs after rollback = %v, want none", ids)
+	}
+	if staged, _ := ioutil.ReadDir("staging"); len(staged) != 0 {
+		t.Fatalf("%d staged files left after rollback", len(staged))
+	}
+
+	user.ID, user.Password = 0, "kq7!Vd2#pLm9"
+	if err := s.WithTx(ctx, create); err != nil {
+		t.Fatal(err)
+	}
+	images, err := s.Image.ByGalleryID(ctx, gallery.ID)
+	if err != nil || len(images) != 1 {
+		t.Fatalf("ByGalleryID after commit = %v, %v, want 1 image", images, err)
+	}
+
+	deleteGallery := func(tx *models.Tx) error {
+		if err := tx.Gallery.Delete(ctx, gallery.ID); err != nil {
+			return err
+		}
+		return tx.Image.DeleteAll(ctx, gallery.ID)
+	}
+	err = s.WithTx(ctx, func(tx *models.Tx) error {
+		if err := deleteGallery(tx); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("WithTx err = %v, want %v", err, errAbort)
+	}
+	if _, err := s.Gallery.ByID(ctx, gallery.ID); err != nil {
+		t.Fatalf("ByID after rollback: %v", err)
+	}
+	if images, _ := s.Image.ByGalleryID(ctx, gallery.ID); len(images) != 1 {
+		t.Fatalf("images after rollback = %v, want 1", images)
+	}
+
+	if err := s.WithTx(ctx, deleteGallery); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Gallery.ByID(ctx, gallery.ID); err != models.ErrNotFound {
+		t.Fatalf("ByID after commit err = %v, want ErrNotFound", err)
+	}
+	if images, _ := s.Image.ByGalleryID(ctx, gallery.ID); len(images) != 0 {
+		t.Fatalf("images after commit = %v, want none", images)
+	}
+}
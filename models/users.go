@@ -1,13 +1,16 @@
 package models
 
 import (
+	"context"
+	"database/sql"
 	"regexp"
 	"strings"
+	"time"
 
 	"soramon0/webapp/lib"
+	"soramon0/webapp/password"
 	"soramon0/webapp/utils"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -17,12 +20,77 @@ import (
 // access to their content
 type User struct {
 	gorm.Model
-	Name         string
-	Email        string `gorm:"not null;unique;index"`
-	Password     string `gorm:"-"`
+	Name  string
+	Email string `gorm:"not null;unique;index"`
+	// Role is one of RoleUser, RoleModerator or RoleAdmin.
+	Role     string `gorm:"not null;default:'user'"`
+	Password string `gorm:"-"`
+	// NoPassword allows creating an account without a password,
+	// e.g. one signed up through an identity provider.
+	NoPassword   bool   `gorm:"-"`
 	PasswordHash string `gorm:"not null"`
 	Remember     string `gorm:"-"`
 	RememberHash string `gorm:"not null;unique;index"`
+	// MagicLink is a single-use login token, only its
+	// HMAC is stored like the remember token.
+	MagicLink          string `gorm:"-"`
+	MagicLinkHash      string `gorm:"index"`
+	MagicLinkExpiresAt *time.Time
+	FailedLogins       int `gorm:"not null;default:0"`
+	LockedUntil        *time.Time
+	// TOTPSecret is encrypted with the TOTP_KEY. It is set when
+	// the enrollment starts but only used once TOTPEnabled is true.
+	TOTPSecret   string
+	TOTPEnabled  bool `gorm:"not null;default:false"`
+	TOTPLastStep int64
+	// BackupCodes holds the space separated HMACs of the
+	// backup codes that were not used yet.
+	BackupCodes string
+	// DeleteAfter is set when the user asked to delete the
+	// account. It is purged once this time has passed.
+	DeleteAfter *time.Time `gorm:"index"`
+	// DisabledAt is set when an admin disabled the account,
+	// disabled users can't sign in.
+	DisabledAt *time.Time
+}
+
+// Roles, from the least to the most privileged. A role has
+// every permission of the roles before it.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// HasRole reports whether the user has role, or a more
+// privileged one.
+func (u *User) HasRole(role string) bool {
+	return roleRanks[u.Role] >= roleRanks[role] && roleRanks[role] > 0
+}
+
+// IsDisabled reports whether an admin disabled the account.
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+// MagicLinkTTL is how long a magic link stays valid.
+const MagicLinkTTL = 15 * time.Minute
+
+// IsLocked reports whether the account is locked at time t.
+func (u *User) IsLocked(t time.Time) bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(t)
+}
+
+// NormalizeEmail lower cases and trims the provided email
+// address the same way it is stored in the database.
+func NormalizeEmail(email string) string {
+	return strings.TrimSpace(strings.ToLower(email))
 }
 
 // UserDB is used to interact with the users database.
@@ -38,14 +106,34 @@ type User struct {
 // probably result in a 500 error.
 type UserDB interface {
 	// Methods for querying for single users
-	ByID(id uint) (*User, error)
-	ByEmail(email string) (*User, error)
-	ByRemember(token string) (*User, error)
+	ByID(ctx context.Context, id uint) (*User, error)
+	ByEmail(ctx context.Context, email string) (*User, error)
+	ByRemember(ctx context.Context, token string) (*User, error)
+	ByMagicLink(ctx context.Context, token string) (*User, error)
+	// Search returns a page of the users whose name or email
+	// contains query, newest first, and the total number of
+	// matching users. An empty query matches everyone.
+	Search(ctx context.Context, query string, limit, offset int) ([]User, int64, error)
+	// PendingDeletion returns the users whose deletion
+	// grace period ended before t.
+	PendingDeletion(ctx context.Context, t time.Time) ([]User, error)
 
 	// Methods for altering users
-	Create(user *User) error
-	Update(user *User) error
-	Delete(id uint) error
+	Create(ctx context.Context, user *User) error
+	Update(ctx context.Context, user *User) error
+	// AddFailedLogin atomically increments the failed logins
+	// of the user and returns their new count.
+	AddFailedLogin(ctx context.Context, id uint) (int, error)
+	// Lock locks the user until t and resets the failed
+	// logins, without touching the other columns.
+	Lock(ctx context.Context, id uint, t time.Time) error
+	// ClearMagicLink atomically clears the magic link whose
+	// hash is one of hashes. It returns ErrNotFound if no user
+	// has it, e.g. it was already used.
+	ClearMagicLink(ctx context.Context, hashes []string) error
+	Delete(ctx context.Context, id uint) error
+	// Purge permanently deletes the user row.
+	Purge(ctx context.Context, id uint) error
 }
 
 // UserService is a set of mthods used to manipulate and
@@ -55,51 +143,391 @@ type UserService interface {
 	// password are correct. If they are correct, the user
 	// corresponding to that email will be returned, Otherwise
 	// it returns either:
-	// ErrNotFound, ErrPasswordInccorect, or another error if
-	// something goes wrong.
-	Authenticate(email, password string) (*User, error)
+	// ErrNotFound, ErrPasswordInccorect, LockedError, or another
+	// error if something goes wrong.
+	Authenticate(ctx context.Context, email, password string) (*User, error)
+	// VerifyPassword checks the password of a signed in user
+	// before a sensitive change. It returns ErrPasswordInccorect
+	// if it does not match, LockedError once the failed attempts
+	// lock the account like in Authenticate, and
+	// ErrPasswordNotSet if the account has no password.
+	VerifyPassword(ctx context.Context, u *User, password string) error
+	// IssueMagicLink generates a single-use login token for the
+	// user, valid for MagicLinkTTL. Issuing a new token
+	// invalidates the previous one.
+	IssueMagicLink(ctx context.Context, u *User) (token string, err error)
+	// ConsumeMagicLink returns the user the token was issued for
+	// and invalidates the token. It returns ErrMagicLinkInvalid
+	// if the token is unknown, was already used or has expired.
+	ConsumeMagicLink(ctx context.Context, token string) (*User, error)
+	// RecordLogin records in the audit log that u signed in,
+	// whichever way they did.
+	RecordLogin(ctx context.Context, u *User) error
+	// ScheduleDeletion marks the account for deletion after
+	// utils.GetAccountDeletionGrace() and signs out all its
+	// sessions. Signing in again cancels the deletion.
+	ScheduleDeletion(ctx context.Context, u *User) error
+	// SetRole changes the role of the user and records it in
+	// the audit log.
+	SetRole(ctx context.Context, u *User, role string) error
+	// As returns the service acting on behalf of a, who is
+	// recorded in the audit log.
+	As(a Actor) UserService
+	TwoFactor
 	UserDB
 }
 
-func NewUserService(db *gorm.DB) UserService {
-	ug := newUserGorm(db)
-	uv := newUserValidator(ug)
+func NewUserService(db *gorm.DB, as AuditDB) UserService {
+	return NewUserServiceWithDB(newUserGorm(db), as)
+}
+
+// NewUserServiceWithDB returns a UserService validating and
+// storing the users in udb, e.g. NewMemoryUserDB in tests.
+func NewUserServiceWithDB(udb UserDB, as AuditDB) UserService {
+	ph := newPasswordHasher()
+	hmac, err := lib.NewHMACFromEnv()
+	utils.Must(err)
+	uv := newUserValidator(udb, hmac, ph, newPasswordPolicy())
+	c, err := lib.NewCipher(utils.GetTOTPKey())
+	utils.Must(err)
 
 	return &userService{
-		UserDB: uv,
+		UserDB:     uv,
+		hmac:       hmac,
+		hasher:     ph,
+		totpCipher: c,
+		auditor:    auditor{as: as},
+	}
+}
+
+// newPasswordHasher builds the password hashing policy from the
+// env config. The legacy PEPPER is always kept as pepper "0"
+// so the hashes made with it still verify.
+func newPasswordHasher() *lib.PasswordHasher {
+	peppers, err := lib.ParsePeppers(utils.GetPeppers())
+	utils.Must(err)
+
+	legacy := lib.Pepper{ID: "0", Key: utils.GetPepper()}
+	hasLegacy := false
+	for _, p := range peppers {
+		hasLegacy = hasLegacy || p.ID == legacy.ID
+	}
+	if !hasLegacy {
+		peppers = append(peppers, legacy)
+	}
+
+	memory, iterations, threads := utils.GetArgon2()
+	ph, err := lib.NewPasswordHasher(lib.PasswordPolicy{
+		Algorithm:  utils.GetPasswordAlgorithm(),
+		BcryptCost: utils.GetBcryptCost(),
+		Argon2: lib.Argon2Params{
+			Memory:  uint32(memory),
+			Time:    uint32(iterations),
+			Threads: uint8(threads),
+		},
+		Peppers:      peppers,
+		LegacyPepper: utils.GetPepper(),
+	})
+	utils.Must(err)
+
+	return ph
+}
+
+// passwordPolicy is the strength required from new passwords.
+type passwordPolicy struct {
+	// MinEntropy is the minimum estimated strength in bits.
+	MinEntropy float64
+	// Breached lists the passwords known to be leaked.
+	Breached *password.BreachedList
+}
+
+// newPasswordPolicy builds the password policy from the env config.
+func newPasswordPolicy() passwordPolicy {
+	breached := password.DefaultBreachedList()
+	if path := utils.GetBreachedPasswords(); path != "" {
+		var err error
+		breached, err = password.OpenBreachedList(path)
+		utils.Must(err)
+	}
+
+	return passwordPolicy{
+		MinEntropy: float64(utils.GetPasswordMinEntropy()),
+		Breached:   breached,
 	}
 }
 
 type userService struct {
 	UserDB
+	hmac       lib.HMAC
+	hasher     *lib.PasswordHasher
+	totpCipher lib.Cipher
+	auditor
+}
+
+// withDB returns the service storing the users in udb, with
+// the same validation, and recording its changes in as.
+func (us *userService) withDB(udb UserDB, as AuditDB) *userService {
+	uv := *us.UserDB.(*userValidator)
+	uv.UserDB = udb
+	c := *us
+	c.UserDB = &uv
+	c.as = as
+	return &c
+}
+
+func (us *userService) As(a Actor) UserService {
+	c := *us
+	c.actor = a
+	return &c
+}
+
+// Create creates the user and records it in the audit log.
+func (us *userService) Create(ctx context.Context, u *User) error {
+	if err := us.UserDB.Create(ctx, u); err != nil {
+		return err
+	}
+
+	return us.record(ctx, AuditUserCreate, "user", u.ID, auditDiff(nil, u.auditFields()))
+}
+
+// Update updates the user. Setting a new password is
+// recorded in the audit log.
+func (us *userService) Update(ctx context.Context, u *User) error {
+	changed := u.Password != ""
+	if err := us.UserDB.Update(ctx, u); err != nil {
+		return err
+	}
+
+	if !changed {
+		return nil
+	}
+	return us.record(ctx, AuditUserPassword, "user", u.ID, nil)
+}
+
+func (us *userService) SetRole(ctx context.Context, u *User, role string) error {
+	old := u.Role
+	u.Role = role
+	if err := us.UserDB.Update(ctx, u); err != nil {
+		u.Role = old
+		return err
+	}
+
+	diff := auditDiff(map[string]interface{}{"role": old}, map[string]interface{}{"role": role})
+	return us.record(ctx, AuditUserRole, "user", u.ID, diff)
+}
+
+// auditFields are the fields of the user shown in the audit
+// log, secrets are never part of it.
+func (u *User) auditFields() map[string]interface{} {
+	return map[string]interface{}{
+		"name":  u.Name,
+		"email": u.Email,
+		"role":  u.Role,
+	}
 }
 
 // Authenticate will verify the provided email address and
 // password are correct. If they are correct, the user
 // corresponding to that email will be returned, Otherwise
 // it returns either:
-// ErrNotFound, ErrPasswordInccorect, or another error if
-// something goes wrong.
-func (us *userService) Authenticate(email, password string) (*User, error) {
-	u, err := us.ByEmail(email)
+// ErrNotFound, ErrPasswordInccorect, LockedError,
+// ErrAccountDisabled, or another error if something goes wrong.
+//
+// After utils.GetLoginMaxFailures() incorrect passwords in a row
+// the account is locked for utils.GetLoginLockout().
+//
+// Passwords hashed with an outdated policy (algorithm, cost or
+// pepper) are hashed again with the current one.
+func (us *userService) Authenticate(ctx context.Context, email, password string) (*User, error) {
+	u, err := us.authenticate(ctx, email, password)
+	switch {
+	case err == nil:
+		// The login is recorded once the session starts, see
+		// RecordLogin, the second factor may still be missing.
+		return u, nil
+	case err == ErrNotFound:
+		// The email is kept to spot attempts on unknown accounts.
+		diff := map[string]change{"email": {To: email}}
+		if rErr := us.record(ctx, AuditUserLoginFailed, "user", 0, diff); rErr != nil {
+			return nil, rErr
+		}
+	case u != nil:
+		if rErr := us.record(ctx, AuditUserLoginFailed, "user", u.ID, nil); rErr != nil {
+			return nil, rErr
+		}
+	}
+
+	return nil, err
+}
+
+// authenticate is Authenticate without the audit log. It
+// also returns the user when the login failed, if known.
+func (us *userService) authenticate(ctx context.Context, email, password string) (*User, error) {
+	u, err := us.ByEmail(ctx, email)
 	if err != nil {
 		return nil, err
 	}
 
-	hpwBytes := []byte(u.PasswordHash)
-	pwByes := []byte(password + utils.GetPepper())
-	err = bcrypt.CompareHashAndPassword(hpwBytes, pwByes)
+	// Accounts linked to an identity provider may have no
+	// password at all, nothing can match it.
+	if u.PasswordHash == "" {
+		return u, ErrPasswordNotSet
+	}
+
+	now := time.Now()
+	if u.IsLocked(now) {
+		return u, LockedError{RetryAfter: u.LockedUntil.Sub(now)}
+	}
+
+	ok, rehash, err := us.hasher.Verify(u.PasswordHash, password)
 	if err != nil {
-		if err == bcrypt.ErrMismatchedHashAndPassword {
-			return nil, ErrPasswordInccorect
+		return u, err
+	}
+	if !ok {
+		return u, us.loginFailed(ctx, u, now)
+	}
+
+	if u.IsDisabled() {
+		return u, ErrAccountDisabled
+	}
+
+	if rehash {
+		// The hash is set directly, the password policy
+		// of the validator only applies to new passwords.
+		hash, err := us.hasher.Hash(password)
+		if err != nil {
+			return u, err
 		}
+		u.PasswordHash = hash
+	}
+
+	if rehash || u.FailedLogins > 0 || u.LockedUntil != nil {
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+		if err := us.Update(ctx, u); err != nil {
+			return u, err
+		}
+	}
+
+	return u, nil
+}
+
+// loginFailed records a failed login attempt and locks the
+// account once there are too many of them. It returns the
+// error Authenticate should return.
+func (us *userService) loginFailed(ctx context.Context, u *User, now time.Time) error {
+	// The count is incremented in the database, concurrent
+	// wrong guesses each see their own attempt.
+	n, err := us.AddFailedLogin(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	u.FailedLogins = n
+	if n < utils.GetLoginMaxFailures() {
+		return ErrPasswordInccorect
+	}
+
+	until := now.Add(utils.GetLoginLockout())
+	if err := us.Lock(ctx, u.ID, until); err != nil {
+		return err
+	}
+	u.LockedUntil = &until
+	u.FailedLogins = 0
+
+	return LockedError{RetryAfter: utils.GetLoginLockout()}
+}
+
+func (us *userService) IssueMagicLink(ctx context.Context, u *User) (string, error) {
+	token, err := lib.RememberToken()
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(MagicLinkTTL)
+	u.MagicLink = token
+	u.MagicLinkExpiresAt = &expires
+	if err := us.Update(ctx, u); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (us *userService) RecordLogin(ctx context.Context, u *User) error {
+	return us.record(ctx, AuditUserLogin, "user", u.ID, nil)
+}
+
+func (us *userService) ConsumeMagicLink(ctx context.Context, token string) (*User, error) {
+	u, err := us.ByMagicLink(ctx, token)
+	if err == ErrNotFound {
+		return nil, ErrMagicLinkInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
 
+	// Only one of the requests racing with the same token
+	// clears it, the others find it gone.
+	err = us.ClearMagicLink(ctx, us.hmac.Hashes(token))
+	if err == ErrNotFound {
+		return nil, ErrMagicLinkInvalid
+	}
+	if err != nil {
 		return nil, err
 	}
 
+	if u.MagicLinkExpiresAt == nil || time.Now().After(*u.MagicLinkExpiresAt) {
+		return nil, ErrMagicLinkInvalid
+	}
+	u.MagicLinkHash = ""
+	u.MagicLinkExpiresAt = nil
+
 	return u, nil
 }
 
+func (us *userService) VerifyPassword(ctx context.Context, u *User, password string) error {
+	if u.PasswordHash == "" {
+		return ErrPasswordNotSet
+	}
+
+	// The guesses share the lockout of the login, a stolen
+	// session can't be used to find the password.
+	now := time.Now()
+	if u.IsLocked(now) {
+		return LockedError{RetryAfter: u.LockedUntil.Sub(now)}
+	}
+
+	ok, _, err := us.hasher.Verify(u.PasswordHash, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return us.loginFailed(ctx, u, now)
+	}
+
+	if u.FailedLogins > 0 || u.LockedUntil != nil {
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+		return us.Update(ctx, u)
+	}
+
+	return nil
+}
+
+func (us *userService) ScheduleDeletion(ctx context.Context, u *User) error {
+	// Nobody gets this token, it only replaces the
+	// one of the signed in sessions.
+	token, err := lib.RememberToken()
+	if err != nil {
+		return err
+	}
+
+	deleteAfter := time.Now().UTC().Add(utils.GetAccountDeletionGrace())
+	u.DeleteAfter = &deleteAfter
+	u.Remember = token
+	return us.Update(ctx, u)
+}
+
 type userValidatorFunc func(*User) error
 
 // runUserValFuncs runs the given fns passing user to each one.
@@ -116,52 +544,95 @@ func runUserValFuncs(user *User, fns ...userValidatorFunc) error {
 type userValidator struct {
 	UserDB
 	hmac       lib.HMAC
+	hasher     *lib.PasswordHasher
+	policy     passwordPolicy
 	emailRegex *regexp.Regexp
 }
 
-func newUserValidator(udb UserDB) *userValidator {
+func newUserValidator(udb UserDB, hmac lib.HMAC, ph *lib.PasswordHasher, policy passwordPolicy) *userValidator {
 	return &userValidator{
 		UserDB:     udb,
-		hmac:       lib.NewHMAC(utils.GetSecret()),
+		hmac:       hmac,
+		hasher:     ph,
+		policy:     policy,
 		emailRegex: regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
 	}
 }
 
 // ByEmail will normalize the provided email and then call
 // ByEmail on the subsequent UserDB layer.
-func (uv *userValidator) ByEmail(email string) (*User, error) {
+func (uv *userValidator) ByEmail(ctx context.Context, email string) (*User, error) {
 	u := User{Email: email}
 
 	if err := runUserValFuncs(&u, uv.emailNormalize); err != nil {
 		return nil, err
 	}
 
-	return uv.UserDB.ByEmail(u.Email)
+	return uv.UserDB.ByEmail(ctx, u.Email)
+}
+
+// ByRemember will hash the remember token with each HMAC key
+// and then call ByRemember on the subsequent UserDB layer.
+// A token found under an old key is hashed again with the
+// primary key.
+func (uv *userValidator) ByRemember(ctx context.Context, token string) (*User, error) {
+	u, err := uv.byHashes(ctx, token, uv.UserDB.ByRemember)
+	if err != nil {
+		return nil, err
+	}
+
+	if !uv.hmac.IsPrimary(u.RememberHash) {
+		u.RememberHash = uv.hmac.Hash(token)
+		if err := uv.UserDB.Update(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+
+	return u, nil
 }
 
-// ByRemember will hash the remember token and then call
-// ByRemember on the subsequent UserDB layer.
-func (uv *userValidator) ByRemember(token string) (*User, error) {
-	u := User{Remember: token}
+// ByMagicLink will hash the magic link token with each HMAC key
+// and then call ByMagicLink on the subsequent UserDB layer.
+func (uv *userValidator) ByMagicLink(ctx context.Context, token string) (*User, error) {
+	u := User{MagicLink: token}
 
-	if err := runUserValFuncs(&u, uv.rememberHmac); err != nil {
+	if err := runUserValFuncs(&u, uv.magicLinkRequired); err != nil {
 		return nil, err
 	}
 
-	return uv.UserDB.ByRemember(u.RememberHash)
+	return uv.byHashes(ctx, token, uv.UserDB.ByMagicLink)
+}
+
+// byHashes calls by with the hash of token under each HMAC
+// key, until a user is found.
+func (uv *userValidator) byHashes(ctx context.Context, token string, by func(ctx context.Context, hash string) (*User, error)) (*User, error) {
+	for _, hash := range uv.hmac.Hashes(token) {
+		u, err := by(ctx, hash)
+		if err == ErrNotFound {
+			continue
+		}
+		return u, err
+	}
+
+	return nil, ErrNotFound
 }
 
 // Create will hash user password and generate a remember token
 // and then call Create on the subsequent UserDB layer.
-func (uv *userValidator) Create(u *User) error {
+func (uv *userValidator) Create(ctx context.Context, u *User) error {
 	fns := []userValidatorFunc{
 		uv.emailNormalize,
 		uv.emailRequired,
 		uv.emailIsValid,
-		uv.emailIsAvail,
+		uv.emailIsAvail(ctx),
+		uv.roleDefault,
+		uv.roleIsValid,
 		uv.passwordRequired,
 		uv.passwordMinLength,
-		uv.passwordBcrypt,
+		uv.passwordEntropy,
+		uv.passwordPersonal,
+		uv.passwordBreached,
+		uv.passwordHash,
 		uv.passwordHashRequired,
 		uv.rememberDefault,
 		uv.rememberHmac,
@@ -172,20 +643,29 @@ func (uv *userValidator) Create(u *User) error {
 		return err
 	}
 
-	return uv.UserDB.Create(u)
+	return uv.UserDB.Create(ctx, u)
 }
 
 // Update generates a new remember token if necessary
 // and then call Update on the subsequent UserDB layer.
-func (uv *userValidator) Update(u *User) error {
+//
+// The password hash is not required, accounts created
+// with NoPassword don't have one.
+func (uv *userValidator) Update(ctx context.Context, u *User) error {
 	fns := []userValidatorFunc{
 		uv.emailNormalize,
 		uv.emailRequired,
 		uv.emailIsValid,
-		uv.emailIsAvail,
+		uv.emailIsAvail(ctx),
+		uv.roleDefault,
+		uv.roleIsValid,
 		uv.passwordMinLength,
-		uv.passwordBcrypt,
-		uv.passwordHashRequired,
+		uv.passwordEntropy,
+		uv.passwordPersonal,
+		uv.passwordBreached,
+		uv.passwordResetsMagicLink,
+		uv.passwordHash,
+		uv.magicLinkHmac,
 		uv.rememberHmac,
 		uv.rememberMinBytes,
 		uv.rememberHashRequired,
@@ -194,44 +674,54 @@ func (uv *userValidator) Update(u *User) error {
 		return err
 	}
 
-	return uv.UserDB.Update(u)
+	return uv.UserDB.Update(ctx, u)
 }
 
 // Delete will call the subsequent UserDB layer if
 // the provided id is valid. Otherwise it will return
 // a ErrIDInvalid.
-func (uv *userValidator) Delete(id uint) error {
+func (uv *userValidator) Delete(ctx context.Context, id uint) error {
+	u := User{Model: gorm.Model{ID: id}}
+
+	if err := runUserValFuncs(&u, uv.isGreaterThan(0)); err != nil {
+		return err
+	}
+
+	return uv.UserDB.Delete(ctx, id)
+}
+
+// Purge will call the subsequent UserDB layer if
+// the provided id is valid
+func (uv *userValidator) Purge(ctx context.Context, id uint) error {
 	u := User{Model: gorm.Model{ID: id}}
 
 	if err := runUserValFuncs(&u, uv.isGreaterThan(0)); err != nil {
 		return err
 	}
 
-	return uv.UserDB.Delete(id)
+	return uv.UserDB.Purge(ctx, id)
 }
 
-// bcryptPassword will hash a user's password with a
-// predefined pepper and bcrypt if the Password field
-// if not empty string
-func (uv *userValidator) passwordBcrypt(u *User) error {
+// passwordHash will hash a user's password with the current
+// password policy if the Password field if not empty string
+func (uv *userValidator) passwordHash(u *User) error {
 	if u.Password == "" {
 		return nil
 	}
 
-	pwBytes := []byte(u.Password + utils.GetPepper())
-	hb, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	hash, err := uv.hasher.Hash(u.Password)
 	if err != nil {
 		return err
 	}
 
-	u.PasswordHash = string(hb)
+	u.PasswordHash = hash
 	u.Password = ""
 
 	return nil
 }
 
 func (uv *userValidator) passwordRequired(u *User) error {
-	if u.Password == "" {
+	if u.Password == "" && !u.NoPassword {
 		return ErrPasswordRequired
 	}
 
@@ -239,7 +729,7 @@ func (uv *userValidator) passwordRequired(u *User) error {
 }
 
 func (uv *userValidator) passwordHashRequired(u *User) error {
-	if u.PasswordHash == "" {
+	if u.PasswordHash == "" && !u.NoPassword {
 		return ErrPasswordRequired
 	}
 
@@ -258,6 +748,89 @@ func (uv *userValidator) passwordMinLength(u *User) error {
 	return nil
 }
 
+// passwordEntropy rejects the passwords whose estimated
+// strength is below the policy.
+func (uv *userValidator) passwordEntropy(u *User) error {
+	if u.Password == "" {
+		return nil
+	}
+
+	if password.Entropy(u.Password) < uv.policy.MinEntropy {
+		return ErrPasswordTooWeak
+	}
+
+	return nil
+}
+
+// passwordPersonal rejects the passwords containing the email
+// address, its local part or a part of the name of the user.
+// It expects the email to be normalized.
+func (uv *userValidator) passwordPersonal(u *User) error {
+	if u.Password == "" {
+		return nil
+	}
+
+	parts := strings.Fields(strings.ToLower(u.Name))
+	if u.Email != "" {
+		parts = append(parts, u.Email, strings.Split(u.Email, "@")[0])
+	}
+
+	pw := strings.ToLower(u.Password)
+	for _, p := range parts {
+		// Very short parts (e.g. initials) are too likely
+		// to appear in any password.
+		if len(p) >= 3 && strings.Contains(pw, p) {
+			return ErrPasswordPersonal
+		}
+	}
+
+	return nil
+}
+
+// passwordBreached rejects the passwords found in the
+// breached password list.
+func (uv *userValidator) passwordBreached(u *User) error {
+	if u.Password == "" || uv.policy.Breached == nil {
+		return nil
+	}
+
+	if uv.policy.Breached.Contains(u.Password) {
+		return ErrPasswordBreached
+	}
+
+	return nil
+}
+
+// passwordResetsMagicLink invalidates the pending magic link
+// when the password changes.
+func (uv *userValidator) passwordResetsMagicLink(u *User) error {
+	if u.Password == "" {
+		return nil
+	}
+
+	u.MagicLinkHash = ""
+	u.MagicLinkExpiresAt = nil
+	return nil
+}
+
+func (uv *userValidator) magicLinkRequired(u *User) error {
+	if u.MagicLink == "" {
+		return ErrMagicLinkInvalid
+	}
+
+	return nil
+}
+
+func (uv *userValidator) magicLinkHmac(u *User) error {
+	if u.MagicLink == "" {
+		return nil
+	}
+
+	u.MagicLinkHash = uv.hmac.Hash(u.MagicLink)
+	u.MagicLink = ""
+	return nil
+}
+
 func (uv *userValidator) rememberDefault(u *User) error {
 	if u.Remember != "" {
 		return nil
@@ -316,8 +889,24 @@ func (uv *userValidator) isGreaterThan(n uint) userValidatorFunc {
 	}
 }
 
+func (uv *userValidator) roleDefault(u *User) error {
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+
+	return nil
+}
+
+func (uv *userValidator) roleIsValid(u *User) error {
+	if _, ok := roleRanks[u.Role]; !ok {
+		return ErrRoleInvalid
+	}
+
+	return nil
+}
+
 func (uv *userValidator) emailNormalize(u *User) error {
-	u.Email = strings.TrimSpace(strings.ToLower(u.Email))
+	u.Email = NormalizeEmail(u.Email)
 	return nil
 }
 
@@ -336,26 +925,28 @@ func (uv *userValidator) emailIsValid(u *User) error {
 	return nil
 }
 
-func (uv *userValidator) emailIsAvail(u *User) error {
-	existing, err := uv.ByEmail(u.Email)
+func (uv *userValidator) emailIsAvail(ctx context.Context) userValidatorFunc {
+	return func(u *User) error {
+		existing, err := uv.ByEmail(ctx, u.Email)
 
-	if err == ErrNotFound {
-		// Email address is not taken
-		return nil
-	}
+		if err == ErrNotFound {
+			// Email address is not taken
+			return nil
+		}
 
-	if err != nil {
-		return err
-	}
+		if err != nil {
+			return err
+		}
 
-	// We found a user w/ this email address...
-	// If the found user has the same ID as this user, it is
-	// an update and this is the same user.
-	if u.ID != existing.ID {
-		return ErrEmailTaken
-	}
+		// We found a user w/ this email address...
+		// If the found user has the same ID as this user, it is
+		// an update and this is the same user.
+		if u.ID != existing.ID {
+			return ErrEmailTaken
+		}
 
-	return nil
+		return nil
+	}
 }
 
 type userGorm struct {
@@ -376,9 +967,9 @@ func newUserGorm(db *gorm.DB) *userGorm {
 //
 // As a general rule, any error but ErrNotFound should
 // probably result in a 500 error
-func (ug *userGorm) ByID(id uint) (*User, error) {
+func (ug *userGorm) ByID(ctx context.Context, id uint) (*User, error) {
 	var u User
-	db := ug.db.Where("id = ?", id)
+	db := ug.db.WithContext(ctx).Where("id = ?", id)
 	err := first(db, &u)
 	return &u, err
 }
@@ -393,9 +984,9 @@ func (ug *userGorm) ByID(id uint) (*User, error) {
 //
 // As a general rule, any error but ErrNotFound should
 // probably result in a 500 error
-func (ug *userGorm) ByEmail(email string) (*User, error) {
+func (ug *userGorm) ByEmail(ctx context.Context, email string) (*User, error) {
 	var u User
-	db := ug.db.Where("email = ?", email)
+	db := ug.db.WithContext(ctx).Where("email = ?", email)
 	err := first(db, &u)
 	return &u, err
 }
@@ -405,29 +996,110 @@ func (ug *userGorm) ByEmail(email string) (*User, error) {
 // token to be already hashed
 //
 // Errors are the same as ByEmail
-func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
+func (ug *userGorm) ByRemember(ctx context.Context, rememberHash string) (*User, error) {
+	var u User
+	db := ug.db.WithContext(ctx).Where("remember_hash = ?", rememberHash)
+	err := first(db, &u)
+	return &u, err
+}
+
+// ByMagicLink looks up a user with the given magic link
+// token and returns that user. This method expects the
+// token to be already hashed
+//
+// Errors are the same as ByEmail
+func (ug *userGorm) ByMagicLink(ctx context.Context, magicLinkHash string) (*User, error) {
 	var u User
-	db := ug.db.Where("remember_hash = ?", rememberHash)
+	db := ug.db.WithContext(ctx).Where("magic_link_hash = ?", magicLinkHash)
 	err := first(db, &u)
 	return &u, err
 }
 
+// Search returns a page of the users whose name or email
+// contains query.
+func (ug *userGorm) Search(ctx context.Context, query string, limit, offset int) ([]User, int64, error) {
+	db := ug.db.WithContext(ctx).Model(&User{})
+	if query != "" {
+		like := "%" + escapeLike(strings.ToLower(query)) + "%"
+		db = db.Where(`LOWER(name) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\'`, like, like)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []User
+	err := db.Order("id desc").Limit(limit).Offset(offset).Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// PendingDeletion returns the users whose DeleteAfter
+// is before t.
+func (ug *userGorm) PendingDeletion(ctx context.Context, t time.Time) ([]User, error) {
+	var users []User
+	// In UTC like delete_after, SQLite compares times as text.
+	err := ug.db.WithContext(ctx).Where("delete_after < ?", t.UTC()).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // Create will create the provided user and backfill data
 // Like the ID, CreatedAt, and UpdatedAt fields.
-func (ug *userGorm) Create(u *User) error {
-	return ug.db.Create(u).Error
+func (ug *userGorm) Create(ctx context.Context, u *User) error {
+	return ug.db.WithContext(ctx).Create(u).Error
 }
 
 // Update will update the provided user with all of the data
 // in the provided user object.
-func (ug *userGorm) Update(u *User) error {
-	return ug.db.Save(u).Error
+func (ug *userGorm) Update(ctx context.Context, u *User) error {
+	return ug.db.WithContext(ctx).Save(u).Error
+}
+
+func (ug *userGorm) AddFailedLogin(ctx context.Context, id uint) (int, error) {
+	var n int
+	err := ug.db.WithContext(ctx).
+		Raw("UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins", id).
+		Row().Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return n, err
+}
+
+func (ug *userGorm) Lock(ctx context.Context, id uint, t time.Time) error {
+	return ug.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"locked_until": t.UTC(), "failed_logins": 0}).Error
+}
+
+func (ug *userGorm) ClearMagicLink(ctx context.Context, hashes []string) error {
+	db := ug.db.WithContext(ctx).Model(&User{}).Where("magic_link_hash IN ?", hashes).
+		Updates(map[string]interface{}{"magic_link_hash": "", "magic_link_expires_at": nil})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
 // Delete will delete the user with the provided ID
-func (ug *userGorm) Delete(id uint) error {
+func (ug *userGorm) Delete(ctx context.Context, id uint) error {
+	user := User{Model: gorm.Model{ID: id}}
+	return ug.db.WithContext(ctx).Delete(&user).Error
+}
+
+// Purge will permanently delete the user with the provided
+// ID, so the email address can be used again.
+func (ug *userGorm) Purge(ctx context.Context, id uint) error {
 	user := User{Model: gorm.Model{ID: id}}
-	return ug.db.Delete(&user).Error
+	return ug.db.WithContext(ctx).Unscoped().Delete(&user).Error
 }
 
 // first will query using the provided gorm.DB and it will
@@ -443,3 +1115,8 @@ func first(db *gorm.DB, dst interface{}) error {
 	}
 	return err
 }
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var _ UserDB = &userMemory{}
+
+// userMemory is a UserDB keeping the users in memory, for tests
+// that don't need a database. It is safe for concurrent use and
+// behaves like userGorm: users are soft deleted, the email and
+// remember hash are unique, even among deleted users, and the
+// lookups return ErrNotFound.
+type userMemory struct {
+	mu     sync.RWMutex
+	users  map[uint]User
+	lastID uint
+}
+
+// NewMemoryUserDB returns an empty in-memory UserDB, to wrap
+// with NewUserServiceWithDB.
+func NewMemoryUserDB() UserDB {
+	return &userMemory{users: map[uint]User{}}
+}
+
+func (um *userMemory) ByID(ctx context.Context, id uint) (*User, error) {
+	return um.find(func(u *User) bool { return u.ID == id })
+}
+
+func (um *userMemory) ByEmail(ctx context.Context, email string) (*User, error) {
+	return um.find(func(u *User) bool { return u.Email == email })
+}
+
+func (um *userMemory) ByRemember(ctx context.Context, rememberHash string) (*User, error) {
+	return um.find(func(u *User) bool { return u.RememberHash == rememberHash })
+}
+
+func (um *userMemory) ByMagicLink(ctx context.Context, magicLinkHash string) (*User, error) {
+	return um.find(func(u *User) bool { return u.MagicLinkHash == magicLinkHash })
+}
+
+func (um *userMemory) Search(ctx context.Context, query string, limit, offset int) ([]User, int64, error) {
+	query = strings.ToLower(query)
+	users := um.filter(func(u *User) bool {
+		return strings.Contains(strings.ToLower(u.Name), query) ||
+			strings.Contains(strings.ToLower(u.Email), query)
+	})
+
+	return usersPage(users, limit, offset), int64(len(users)), nil
+}
+
+func (um *userMemory) PendingDeletion(ctx context.Context, t time.Time) ([]User, error) {
+	users := um.filter(func(u *User) bool {
+		return u.DeleteAfter != nil && u.DeleteAfter.Before(t)
+	})
+	return users, nil
+}
+
+func (um *userMemory) Create(ctx context.Context, u *User) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	if err := um.unique(u); err != nil {
+		return err
+	}
+
+	um.lastID++
+	now := time.Now()
+	u.ID = um.lastID
+	u.CreatedAt = now
+	u.UpdatedAt = now
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+	um.users[u.ID] = *u
+	return nil
+}
+
+func (um *userMemory) Update(ctx context.Context, u *User) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	if _, ok := um.users[u.ID]; !ok {
+		return ErrNotFound
+	}
+	if err := um.unique(u); err != nil {
+		return err
+	}
+
+	u.UpdatedAt = time.Now()
+	um.users[u.ID] = *u
+	return nil
+}
+
+func (um *userMemory) AddFailedLogin(ctx context.Context, id uint) (int, error) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	u, ok := um.users[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	u.FailedLogins++
+	um.users[id] = u
+	return u.FailedLogins, nil
+}
+
+func (um *userMemory) Lock(ctx context.Context, id uint, t time.Time) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	u, ok := um.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	u.LockedUntil = &t
+	u.FailedLogins = 0
+	um.users[id] = u
+	return nil
+}
+
+func (um *userMemory) ClearMagicLink(ctx context.Context, hashes []string) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	cleared := false
+	for id, u := range um.users {
+		if u.DeletedAt.Valid || u.MagicLinkHash == "" {
+			continue
+		}
+		for _, hash := range hashes {
+			if u.MagicLinkHash == hash {
+				u.MagicLinkHash = ""
+				u.MagicLinkExpiresAt = nil
+				um.users[id] = u
+				cleared = true
+				break
+			}
+		}
+	}
+
+	if !cleared {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (um *userMemory) Delete(ctx context.Context, id uint) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	u, ok := um.users[id]
+	if !ok || u.DeletedAt.Valid {
+		return nil
+	}
+
+	u.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	um.users[id] = u
+	return nil
+}
+
+func (um *userMemory) Purge(ctx context.Context, id uint) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	delete(um.users, id)
+	return nil
+}
+
+// unique checks the unique constraints of the users table
+// against the other users. um.mu must be held.
+func (um *userMemory) unique(u *User) error {
+	for id, other := range um.users {
+		if id == u.ID {
+			continue
+		}
+		if other.Email == u.Email {
+			return ErrEmailTaken
+		}
+		if other.RememberHash == u.RememberHash {
+			return ErrRememberTaken
+		}
+	}
+
+	return nil
+}
+
+// find returns a copy of the user with the lowest ID
+// matching fn that is not deleted, like first in gorm.
+func (um *userMemory) find(fn func(u *User) bool) (*User, error) {
+	users := um.filter(fn)
+	if len(users) == 0 {
+		return nil, ErrNotFound
+	}
+
+	// filter returns the newest first, the lowest ID is last.
+	u := users[len(users)-1]
+	return &u, nil
+}
+
+// filter returns copies of the users matching fn that are not
+// deleted, newest first.
+func (um *userMemory) filter(fn func(u *User) bool) []User {
+	um.mu.RLock()
+	defer um.mu.RUnlock()
+
+	var users []User
+	for _, u := range um.users {
+		if !u.DeletedAt.Valid && fn(&u) {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID > users[j].ID
+	})
+
+	return users
+}
+
+// usersPage returns the page of items starting at offset, a
+// negative limit returns all of them like in gorm.
+func usersPage(items []User, limit, offset int) []User {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+
+	return items
+}
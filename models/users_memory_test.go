@@ -0,0 +1,132 @@
+package models_test
+
+import (
+	"context"
+	"testing"
+
+	"soramon0/webapp/lib"
+	"soramon0/webapp/models"
+	"soramon0/webapp/utils"
+
+	"github.com/nicholasjackson/env"
+)
+
+func TestMemoryUserDB(t *testing.T) {
+	ctx := context.Background()
+	utils.Must(env.Parse())
+	us := models.NewUserServiceWithDB(models.NewMemoryUserDB(), nil)
+
+	token, err := lib.RememberToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{
+		Name:     "Sam Lee",
+		Email:    "Sam@Test.com",
+		Password: "kq7!Vd2#pLm9",
+		Remember: token,
+	}
+	if err := us.Create(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == 0 {
+		t.Errorf("Expected ID > 0. Recieved %d", user.ID)
+	}
+
+	found, err := us.ByRemember(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != user.ID || found.Email != "sam@test.com" {
+		t.Errorf("Expected user %d sam@test.com. Recieved %d %s", user.ID, found.ID, found.Email)
+	}
+
+	other := models.User{Name: "Sam", Email: "sam@test.com", Password: "kq7!Vd2#pLm9"}
+	if err := us.Create(ctx, &other); err != models.ErrEmailTaken {
+		t.Errorf("Expected %v. Recieved %v", models.ErrEmailTaken, err)
+	}
+	other = models.User{Name: "Sam", Email: "sam.lee@test.com", Password: "kq7!Vd2#pLm9", Remember: token}
+	if err := us.Create(ctx, &other); err != models.ErrRememberTaken {
+		t.Errorf("Expected %v. Recieved %v", models.ErrRememberTaken, err)
+	}
+	other = models.User{Name: "Sam", Email: "sam@test.com", Password: "kq7!Vd2#pLm9"}
+
+	if _, err := us.Authenticate(ctx, "sam@test.com", "kq7!Vd2#pLm9"); err != nil {
+		t.Errorf("Expected to authenticate. Recieved %v", err)
+	}
+
+	if err := us.Delete(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.ByID(ctx, user.ID); err != models.ErrNotFound {
+		t.Errorf("Expected %v. Recieved %v", models.ErrNotFound, err)
+	}
+	// The email stays taken until the user is purged.
+	if err := us.Create(ctx, &other); err != models.ErrEmailTaken {
+		t.Errorf("Expected %v. Recieved %v", models.ErrEmailTaken, err)
+	}
+	if err := us.Purge(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	other = models.User{Name: "Sam", Email: "sam@test.com", Password: "kq7!Vd2#pLm9"}
+	if err := us.Create(ctx, &other); err != nil {
+		t.Errorf("Expected to create the user again. Recieved %v", err)
+	}
+}
+
+func TestMemoryGalleryDB(t *testing.T) {
+	ctx := context.Background()
+	gs := models.NewGalleryServiceWithDB(models.NewMemoryGalleryDB(), nil)
+
+	if err := gs.Create(ctx, &models.Gallery{UserID: 1}); err != models.ErrTitleRequired {
+		t.Errorf("Expected %v. Recieved %v", models.ErrTitleRequired, err)
+	}
+
+	for _, title := range []string{"Beach", "Mountains", "City"} {
+		if err := gs.Create(ctx, &models.Gallery{UserID: 1, Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gs.Create(ctx, &models.Gallery{UserID: 2, Title: "Other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	galleries, total, err := gs.List(ctx, 1, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(galleries) != 2 || galleries[0].Title != "City" {
+		t.Errorf("Expected 2 of 3 galleries newest first. Recieved %d of %d %v", len(galleries), total, galleries)
+	}
+
+	if err := gs.Delete(ctx, galleries[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	galleries, err = gs.ByUserID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(galleries) != 2 || galleries[0].Title != "Beach" {
+		t.Errorf("Expected the 2 remaining galleries. Recieved %v", galleries)
+	}
+	if err := gs.Update(ctx, &models.Gallery{Title: "Missing", UserID: 1}); err != models.ErrNotFound {
+		t.Errorf("Expected %v. Recieved %v", models.ErrNotFound, err)
+	}
+
+	deleted, err := gs.DeletedByUserID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].Title != "City" {
+		t.Fatalf("Expected the deleted gallery in the trash. Recieved %v", deleted)
+	}
+	if err := gs.Restore(ctx, deleted[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gs.ByID(ctx, deleted[0].ID); err != nil {
+		t.Errorf("Expected the restored gallery. Recieved %v", err)
+	}
+	if _, err := gs.DeletedByID(ctx, deleted[0].ID); err != models.ErrNotFound {
+		t.Errorf("Expected %v. Recieved %v", models.ErrNotFound, err)
+	}
+}
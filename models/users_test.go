@@ -1,30 +1,62 @@
 package models_test
 
 import (
+	"context"
+	"sync"
 	"testing"
+	"time"
 
+	"soramon0/webapp/lib"
 	"soramon0/webapp/models"
 	"soramon0/webapp/utils"
 
 	"github.com/nicholasjackson/env"
 )
 
-func testingUserService() models.UserService {
+// testingServices runs fn on reset services of every database
+// driver, a Postgres database configured like the app and an
+// SQLite one in memory.
+func testingServices(t *testing.T, fn func(t *testing.T, s *models.Services)) {
 	utils.Must(env.Parse())
-	s := models.NewServices()
-	s.DestructiveReset()
-	return s.User
+	drivers := []struct {
+		name string
+		dsn  string
+	}{
+		{lib.DriverPostgres, utils.GetDB()},
+		{lib.DriverSQLite, ":memory:"},
+	}
+
+	for _, d := range drivers {
+		t.Run(d.name, func(t *testing.T) {
+			db, err := lib.OpenDB(d.name, d.dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := models.NewServicesWithDB(db)
+			defer s.Close()
+			if err := s.DestructiveReset(); err != nil {
+				t.Fatal(err)
+			}
+
+			fn(t, s)
+		})
+	}
 }
 
 func TestCreateUser(t *testing.T) {
-	us := testingUserService()
+	testingServices(t, func(t *testing.T, s *models.Services) {
+		testCreateUser(t, s.User)
+	})
+}
 
+func testCreateUser(t *testing.T, us models.UserService) {
+	ctx := context.Background()
 	user := models.User{
 		Name:     "Sam Lee",
 		Email:    "sam@test.com",
-		Password: "password",
+		Password: "kq7!Vd2#pLm9",
 	}
-	err := us.Create(&user)
+	err := us.Create(ctx, &user)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -33,3 +65,132 @@ func TestCreateUser(t *testing.T) {
 		t.Errorf("Expected ID > 0. Recieved %d", user.ID)
 	}
 }
+
+func TestLoginLockout(t *testing.T) {
+	testingServices(t, testLoginLockout)
+}
+
+func testLoginLockout(t *testing.T, s *models.Services) {
+	ctx := context.Background()
+	// Create clears user.Password once it is hashed.
+	password := "kq7!Vd2#pLm9"
+	user := models.User{
+		Name:     "Sam Lee",
+		Email:    "sam@test.com",
+		Password: password,
+	}
+	if err := s.User.Create(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent wrong guesses must all be counted.
+	guesses := utils.GetLoginMaxFailures() - 1
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.User.Authenticate(ctx, user.Email, "wrong password")
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.User.ByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FailedLogins != guesses {
+		t.Fatalf("FailedLogins = %d, want %d", got.FailedLogins, guesses)
+	}
+
+	_, err = s.User.Authenticate(ctx, user.Email, "wrong password")
+	if _, ok := err.(models.LockedError); !ok {
+		t.Fatalf("Authenticate err = %v, want LockedError", err)
+	}
+	_, err = s.User.Authenticate(ctx, user.Email, password)
+	if _, ok := err.(models.LockedError); !ok {
+		t.Fatalf("Authenticate of a locked account err = %v, want LockedError", err)
+	}
+}
+
+func TestMagicLink(t *testing.T) {
+	testingServices(t, testMagicLink)
+}
+
+func testMagicLink(t *testing.T, s *models.Services) {
+	ctx := context.Background()
+	user := models.User{
+		Name:     "Sam Lee",
+		Email:    "sam@test.com",
+		Password: "kq7!Vd2#pLm9",
+	}
+	if err := s.User.Create(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("reuse", func(t *testing.T) {
+		token, err := s.User.IssueMagicLink(ctx, &user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Concurrent requests with the same token sign in once.
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		signedIn := 0
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, err := s.User.ConsumeMagicLink(ctx, token)
+				if err != nil && err != models.ErrMagicLinkInvalid {
+					t.Error(err)
+				}
+				if err == nil && got.ID == user.ID {
+					mu.Lock()
+					signedIn++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if signedIn != 1 {
+			t.Fatalf("Signed in %d times with the same link, want 1", signedIn)
+		}
+
+		if _, err := s.User.ConsumeMagicLink(ctx, token); err != models.ErrMagicLinkInvalid {
+			t.Errorf("ConsumeMagicLink of a used link err = %v, want ErrMagicLinkInvalid", err)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		token, err := s.User.IssueMagicLink(ctx, &user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expired := time.Now().Add(-time.Minute)
+		user.MagicLinkExpiresAt = &expired
+		if err := s.User.Update(ctx, &user); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.User.ConsumeMagicLink(ctx, token); err != models.ErrMagicLinkInvalid {
+			t.Errorf("ConsumeMagicLink of an expired link err = %v, want ErrMagicLinkInvalid", err)
+		}
+	})
+
+	t.Run("password change", func(t *testing.T) {
+		token, err := s.User.IssueMagicLink(ctx, &user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Password = "vB3#nQ8!zRt5"
+		if err := s.User.Update(ctx, &user); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.User.ConsumeMagicLink(ctx, token); err != models.ErrMagicLinkInvalid {
+			t.Errorf("ConsumeMagicLink after a password change err = %v, want ErrMagicLinkInvalid", err)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is the clock drift tolerated between us
+// and the provider.
+const clockSkew = time.Minute
+
+var (
+	ErrMalformedToken = errors.New("oidc: malformed ID token")
+	ErrUnsupportedAlg = errors.New("oidc: unsupported signing algorithm")
+	ErrSignature      = errors.New("oidc: invalid ID token signature")
+	ErrClaims         = errors.New("oidc: invalid ID token claims")
+	ErrExpired        = errors.New("oidc: ID token expired")
+	ErrNonce          = errors.New("oidc: nonce mismatch")
+)
+
+// Claims are the ID token claims we use.
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   bool     `json:"email_verified"`
+	Name            string   `json:"name"`
+}
+
+// audience is either a single string or an array of
+// strings in the ID token.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var arr []string
+	if err := json.Unmarshal(b, &arr); err != nil {
+		return err
+	}
+	*a = arr
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyIDToken checks the signature of the ID token against
+// the provider keys, then its issuer, audience, expiry and
+// nonce (OIDC Core 3.1.3.7) and returns its claims.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	if _, err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	key, err := p.keys.get(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWS(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case c.Issuer != p.cfg.Issuer, c.Subject == "":
+		return nil, ErrClaims
+	case !c.Audience.contains(p.cfg.ClientID):
+		return nil, ErrClaims
+	case len(c.Audience) > 1 && c.AuthorizedParty != p.cfg.ClientID:
+		return nil, ErrClaims
+	case now.After(time.Unix(c.Expiry, 0).Add(clockSkew)):
+		return nil, ErrExpired
+	case time.Unix(c.IssuedAt, 0).After(now.Add(clockSkew)):
+		return nil, ErrClaims
+	case subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1:
+		return nil, ErrNonce
+	}
+
+	return &c, nil
+}
+
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	h := sha256.Sum256(signed)
+
+	switch alg {
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlg
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig); err != nil {
+			return ErrSignature
+		}
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, h[:], r, s) {
+			return ErrSignature
+		}
+	default:
+		// This rejects "none" and the HMAC algorithms which
+		// would let anybody knowing the client secret sign.
+		return ErrUnsupportedAlg
+	}
+
+	return nil
+}
+
+func decodeSegment(seg string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformedToken
+	}
+
+	if err := json.Unmarshal(b, dst); err != nil {
+		return ErrMalformedToken
+	}
+
+	return nil
+}
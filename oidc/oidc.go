@@ -0,0 +1,222 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"soramon0/webapp/lib"
+)
+
+const (
+	// pkceVerifierBytes gives a 43 characters verifier,
+	// the minimum allowed by RFC 7636.
+	pkceVerifierBytes = 32
+	// maxResponseBody limits what we read from the provider.
+	maxResponseBody = 1 << 20
+)
+
+var (
+	ErrNotConfigured = errors.New("oidc: provider is not configured")
+	ErrDiscovery     = errors.New("oidc: invalid discovery document")
+	ErrExchange      = errors.New("oidc: code exchange failed")
+	ErrNoIDToken     = errors.New("oidc: token response has no id_token")
+)
+
+// Config describes an OpenID Connect provider and our
+// client registration with it.
+type Config struct {
+	// Name is shown to users, e.g. "Company SSO".
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested on top of "openid".
+	Scopes     []string
+	HTTPClient *http.Client
+}
+
+// Provider runs the authorization code flow with PKCE against
+// an OpenID Connect provider. The discovery document and the
+// signing keys are fetched lazily and cached.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     *keySet
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewProvider(cfg Config) *Provider {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Provider{
+		cfg:    cfg,
+		client: client,
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// Issuer is the issuer identifier of the provider, it is
+// used to namespace the subjects of the provider's users.
+func (p *Provider) Issuer() string {
+	return p.cfg.Issuer
+}
+
+// Enabled reports whether the provider was configured.
+func (p *Provider) Enabled() bool {
+	return p.cfg.Issuer != "" && p.cfg.ClientID != ""
+}
+
+// PKCE generates a code verifier and its S256 challenge.
+func PKCE() (verifier, challenge string, err error) {
+	verifier, err = lib.Base64FromBytes(pkceVerifierBytes)
+	if err != nil {
+		return "", "", err
+	}
+	verifier = strings.TrimRight(verifier, "=")
+
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthCodeURL returns the URL of the provider's consent page.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", challenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return md.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens and
+// returns the verified claims of the ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	var tok struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := doJSON(p.client, req, &tok); err != nil {
+		return nil, err
+	}
+	if tok.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrExchange, tok.Error)
+	}
+	if tok.IDToken == "" {
+		return nil, ErrNoIDToken
+	}
+
+	return p.VerifyIDToken(ctx, tok.IDToken, nonce)
+}
+
+// discover fetches the discovery document the first time
+// it is needed.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	if !p.Enabled() {
+		return nil, ErrNotConfigured
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	u := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var md metadata
+	if err := doJSON(p.client, req, &md); err != nil {
+		return nil, err
+	}
+
+	// The issuer must match exactly (OIDC Discovery 4.3).
+	if md.Issuer != p.cfg.Issuer || md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, ErrDiscovery
+	}
+
+	p.metadata = &md
+	p.keys = newKeySet(p.client, md.JWKSURI)
+	return p.metadata, nil
+}
+
+// doJSON sends req and decodes the JSON response into dst.
+func doJSON(client *http.Client, req *http.Request, dst interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("oidc: %s returned %s", req.URL.Redacted(), res.Status)
+	}
+
+	return json.Unmarshal(body, dst)
+}
@@ -0,0 +1,109 @@
+package oidc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"soramon0/webapp/oidc"
+	"soramon0/webapp/oidc/oidctest"
+)
+
+const redirectURL = "http://localhost:3000/auth/oidc/callback"
+
+func testingProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	mock := oidctest.New("webapp", "s3cret")
+	t.Cleanup(mock.Close)
+
+	p := oidc.NewProvider(oidc.Config{
+		Name:         "Test",
+		Issuer:       mock.Issuer(),
+		ClientID:     "webapp",
+		ClientSecret: "s3cret",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email", "profile"},
+	})
+	return mock, p
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	mock, p := testingProvider(t)
+	ctx := context.Background()
+
+	verifier, challenge, err := oidc.PKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback, err := mock.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Query().Get("state") != "state" {
+		t.Fatalf("Expected state to be passed back. Recieved %q", callback.Query().Get("state"))
+	}
+
+	code := callback.Query().Get("code")
+	if _, err := p.Exchange(ctx, code, "wrong-verifier", "nonce"); err == nil {
+		t.Fatal("Expected exchange with a wrong PKCE verifier to fail")
+	}
+
+	callback, _ = mock.Authorize(authURL)
+	claims, err := p.Exchange(ctx, callback.Query().Get("code"), verifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != mock.User.Subject || claims.Email != mock.User.Email || !claims.EmailVerified {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	mock, p := testingProvider(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   mock.Issuer(),
+			"sub":   "42",
+			"aud":   "webapp",
+			"exp":   now.Add(time.Hour).Unix(),
+			"iat":   now.Unix(),
+			"nonce": "nonce",
+		}
+	}
+
+	if _, err := p.VerifyIDToken(ctx, mock.Sign(valid()), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		key   string
+		value interface{}
+		err   error
+	}{
+		{"wrong issuer", "iss", "https://evil.example", oidc.ErrClaims},
+		{"wrong audience", "aud", "someone-else", oidc.ErrClaims},
+		{"expired", "exp", now.Add(-time.Hour).Unix(), oidc.ErrExpired},
+		{"wrong nonce", "nonce", "other", oidc.ErrNonce},
+	}
+	for _, c := range cases {
+		claims := valid()
+		claims[c.key] = c.value
+		if _, err := p.VerifyIDToken(ctx, mock.Sign(claims), "nonce"); err != c.err {
+			t.Errorf("%s: Expected %v. Recieved %v", c.name, c.err, err)
+		}
+	}
+
+	token := mock.Sign(valid())
+	if _, err := p.VerifyIDToken(ctx, token[:len(token)-4]+"AAAA", "nonce"); err != oidc.ErrSignature {
+		t.Errorf("Expected ErrSignature. Recieved %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// hashBytes is the size of the truncated SHA-1 hashes stored in
+// a breached list. 64 bits keep the false positive rate
+// negligible for lists of millions of passwords.
+const hashBytes = 8
+
+// ErrMalformedList is returned when a breached list is not a
+// sorted sequence of 8 byte hashes.
+var ErrMalformedList = errors.New("password: malformed breached list")
+
+//go:embed breached.bin
+var defaultList []byte
+
+// BreachedList is a set of known breached passwords, stored as
+// the sorted first 8 bytes of their SHA-1 hashes. It is safe
+// for concurrent use.
+type BreachedList struct {
+	hashes []byte
+}
+
+// DefaultBreachedList returns the list shipped with the binary,
+// made of the most common passwords.
+func DefaultBreachedList() *BreachedList {
+	return &BreachedList{hashes: defaultList}
+}
+
+// NewBreachedList creates a list from data, which must be
+// written by WriteBreachedList.
+func NewBreachedList(data []byte) (*BreachedList, error) {
+	if len(data)%hashBytes != 0 {
+		return nil, ErrMalformedList
+	}
+
+	l := &BreachedList{hashes: data}
+	for i := 1; i < l.Len(); i++ {
+		if l.at(i-1) > l.at(i) {
+			return nil, ErrMalformedList
+		}
+	}
+
+	return l, nil
+}
+
+// OpenBreachedList reads the list from the file at path.
+func OpenBreachedList(path string) (*BreachedList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBreachedList(data)
+}
+
+// Len returns the number of hashes in the list.
+func (l *BreachedList) Len() int {
+	return len(l.hashes) / hashBytes
+}
+
+// Contains reports whether password, or its lower case
+// version, is in the list.
+func (l *BreachedList) Contains(password string) bool {
+	if l.contains(hash(password)) {
+		return true
+	}
+
+	lower := strings.ToLower(password)
+	return lower != password && l.contains(hash(lower))
+}
+
+func (l *BreachedList) contains(h uint64) bool {
+	n := l.Len()
+	i := sort.Search(n, func(i int) bool { return l.at(i) >= h })
+	return i < n && l.at(i) == h
+}
+
+func (l *BreachedList) at(i int) uint64 {
+	return binary.BigEndian.Uint64(l.hashes[i*hashBytes:])
+}
+
+// WriteBreachedList reads newline separated passwords from r
+// and writes them to w as a breached list.
+func WriteBreachedList(w io.Writer, r io.Reader) error {
+	var hashes []uint64
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		p := strings.TrimRight(s.Text(), "\r")
+		if p == "" {
+			continue
+		}
+		hashes = append(hashes, hash(p))
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, hashBytes)
+	for i, h := range hashes {
+		if i > 0 && h == hashes[i-1] {
+			continue
+		}
+		binary.BigEndian.PutUint64(buf, h)
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func hash(password string) uint64 {
+	sum := sha1.Sum([]byte(password))
+	return binary.BigEndian.Uint64(sum[:hashBytes])
+}
@@ -0,0 +1,22 @@
+// Command genbreached builds a breached password list from a
+// newline separated password list, e.g. one from SecLists or
+// the Have I Been Pwned dumps:
+//
+//	go run ./password/internal/genbreached < passwords.txt > breached.bin
+//
+// The result can replace password/breached.bin or be loaded
+// with BREACHED_PASSWORDS.
+package main
+
+import (
+	"log"
+	"os"
+
+	"soramon0/webapp/password"
+)
+
+func main() {
+	if err := password.WriteBreachedList(os.Stdout, os.Stdin); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,70 @@
+package password
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	weak := []string{"", "aaaaaaaa", "abcdefgh", "12345678", "password", "Abcd1234"}
+	for _, p := range weak {
+		if e := Entropy(p); e >= 36 {
+			t.Errorf("Entropy(%q) = %.1f, want < 36", p, e)
+		}
+	}
+
+	strong := []string{"correcthorsebattery", "Tr0ub4dor&3", "kq7!Vd2#pLm9", "ĉiuj ŝafoj manĝas"}
+	for _, p := range strong {
+		if e := Entropy(p); e < 36 {
+			t.Errorf("Entropy(%q) = %.1f, want >= 36", p, e)
+		}
+	}
+}
+
+func TestBreachedList(t *testing.T) {
+	var buf bytes.Buffer
+	in := "hunter2\nletmein\r\n\nletmein\nCorrectHorse\n"
+	if err := WriteBreachedList(&buf, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewBreachedList(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", l.Len())
+	}
+
+	for _, p := range []string{"hunter2", "letmein", "LetMeIn", "CorrectHorse"} {
+		if !l.Contains(p) {
+			t.Errorf("Contains(%q) = false", p)
+		}
+	}
+	for _, p := range []string{"hunter3", "correcthorse", ""} {
+		if l.Contains(p) {
+			t.Errorf("Contains(%q) = true", p)
+		}
+	}
+}
+
+func TestNewBreachedListMalformed(t *testing.T) {
+	if _, err := NewBreachedList(make([]byte, 7)); err != ErrMalformedList {
+		t.Errorf("short list: err = %v, want ErrMalformedList", err)
+	}
+
+	unsorted := []byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 1}
+	if _, err := NewBreachedList(unsorted); err != ErrMalformedList {
+		t.Errorf("unsorted list: err = %v, want ErrMalformedList", err)
+	}
+}
+
+func TestDefaultBreachedList(t *testing.T) {
+	l := DefaultBreachedList()
+	for _, p := range []string{"password", "123456", "qwerty123"} {
+		if !l.Contains(p) {
+			t.Errorf("Contains(%q) = false", p)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package password
+
+import (
+	"math"
+	"unicode"
+)
+
+// Character pool sizes used by Entropy.
+const (
+	poolLower  = 26
+	poolUpper  = 26
+	poolDigit  = 10
+	poolSymbol = 33
+	poolOther  = 100
+)
+
+// Entropy estimates the strength of a password in bits.
+//
+// Every character is worth log2 of the size of the character
+// classes used by the whole password. Characters repeating or
+// continuing a sequence with the previous one ("aa", "ab", "21")
+// are worth a single bit, characters already used earlier are
+// worth half. This is a rough estimate meant to reject the
+// obviously weak passwords, not a guess counter.
+func Entropy(password string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, c := range []struct {
+		used bool
+		size int
+	}{{lower, poolLower}, {upper, poolUpper}, {digit, poolDigit}, {symbol, poolSymbol}, {other, poolOther}} {
+		if c.used {
+			pool += c.size
+		}
+	}
+	bits := math.Log2(float64(pool))
+
+	var entropy float64
+	seen := make(map[rune]bool, len(runes))
+	for i, r := range runes {
+		switch {
+		case i > 0 && abs(r-runes[i-1]) <= 1:
+			entropy++
+		case seen[r]:
+			entropy += bits / 2
+		default:
+			entropy += bits
+		}
+		seen[r] = true
+	}
+
+	return entropy
+}
+
+func abs(r rune) rune {
+	if r < 0 {
+		return -r
+	}
+	return r
+}
@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"soramon0/webapp/models"
+)
+
+// Action is something a user wants to do with a resource.
+type Action string
+
+const (
+	View     Action = "view"
+	Update   Action = "update"
+	Delete   Action = "delete"
+	Download Action = "download"
+	// Manage covers the admin actions on users, e.g.
+	// disabling an account or forcing a password reset.
+	Manage Action = "manage"
+	// Impersonate is acting as the user for support.
+	Impersonate Action = "impersonate"
+)
+
+// Can reports whether user may perform action on resource.
+// user is nil for visitors who are not signed in.
+//
+// Owners can do anything with their resources and admins can
+// do it for everyone, except downloading personal data exports
+// which is only ever allowed to their owner. Moderators can
+// delete any gallery. Only admins can impersonate users, and
+// never other admins.
+func Can(user *models.User, action Action, resource interface{}) bool {
+	switch res := resource.(type) {
+	case *models.Gallery:
+		return canGallery(user, action, res)
+	case *models.Credential:
+		return owns(user, res.UserID) || isAdmin(user)
+	case *models.Export:
+		return action == Download && owns(user, res.UserID)
+	case *models.User:
+		switch action {
+		case Manage:
+			return isAdmin(user)
+		case Impersonate:
+			// Admins can't act as each other, or as themselves.
+			return isAdmin(user) && !isAdmin(res) && !owns(user, res.ID)
+		}
+		return owns(user, res.ID) || isAdmin(user)
+	}
+
+	return false
+}
+
+func canGallery(user *models.User, action Action, g *models.Gallery) bool {
+	switch action {
+	case View:
+		// Galleries are public.
+		return true
+	case Delete:
+		if user != nil && user.HasRole(models.RoleModerator) {
+			return true
+		}
+	}
+
+	return owns(user, g.UserID) || isAdmin(user)
+}
+
+func owns(user *models.User, userID uint) bool {
+	return user != nil && user.ID != 0 && user.ID == userID
+}
+
+func isAdmin(user *models.User) bool {
+	return user != nil && user.HasRole(models.RoleAdmin)
+}
@@ -0,0 +1,61 @@
+package policy
+
+import (
+	"testing"
+
+	"soramon0/webapp/models"
+
+	"gorm.io/gorm"
+)
+
+func user(id uint, role string) *models.User {
+	return &models.User{Model: gorm.Model{ID: id}, Role: role}
+}
+
+func TestCan(t *testing.T) {
+	owner := user(1, models.RoleUser)
+	other := user(2, models.RoleUser)
+	mod := user(3, models.RoleModerator)
+	admin := user(4, models.RoleAdmin)
+
+	gallery := &models.Gallery{UserID: owner.ID}
+	credential := &models.Credential{UserID: owner.ID}
+	export := &models.Export{UserID: owner.ID}
+
+	cases := []struct {
+		name     string
+		user     *models.User
+		action   Action
+		resource interface{}
+		want     bool
+	}{
+		{"visitor views gallery", nil, View, gallery, true},
+		{"visitor updates gallery", nil, Update, gallery, false},
+		{"owner updates gallery", owner, Update, gallery, true},
+		{"other updates gallery", other, Update, gallery, false},
+		{"other deletes gallery", other, Delete, gallery, false},
+		{"moderator updates gallery", mod, Update, gallery, false},
+		{"moderator deletes gallery", mod, Delete, gallery, true},
+		{"admin updates gallery", admin, Update, gallery, true},
+		{"owner deletes passkey", owner, Delete, credential, true},
+		{"other deletes passkey", other, Delete, credential, false},
+		{"admin deletes passkey", admin, Delete, credential, true},
+		{"owner downloads export", owner, Download, export, true},
+		{"admin downloads export", admin, Download, export, false},
+		{"owner manages self", owner, Manage, owner, false},
+		{"moderator manages user", mod, Manage, owner, false},
+		{"admin manages user", admin, Manage, owner, true},
+		{"admin impersonates user", admin, Impersonate, owner, true},
+		{"admin impersonates admin", admin, Impersonate, user(6, models.RoleAdmin), false},
+		{"admin impersonates self", admin, Impersonate, admin, false},
+		{"moderator impersonates user", mod, Impersonate, owner, false},
+		{"unknown resource", admin, View, "gallery", false},
+		{"unknown role", user(5, "root"), Update, gallery, false},
+	}
+
+	for _, c := range cases {
+		if got := Can(c.user, c.action, c.resource); got != c.want {
+			t.Errorf("%s: Can = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery is the number of Take calls between two
+// removals of full buckets.
+const sweepEvery = 1024
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rate   Rate
+}
+
+// MemoryStore keeps the buckets in memory. It is safe for
+// concurrent use, but limits are per process.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(key string, rate Rate) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.calls++
+	if s.calls%sweepEvery == 0 {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Burst), last: now, rate: rate}
+		s.buckets[key] = b
+	}
+	b.refill(now)
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / rate.PerSecond()
+		return Result{
+			Allowed:    false,
+			RetryAfter: time.Duration(math.Ceil(wait * float64(time.Second))),
+		}, nil
+	}
+
+	b.tokens--
+	return Result{
+		Allowed:   true,
+		Remaining: int(b.tokens),
+	}, nil
+}
+
+// Len returns the number of buckets currently tracked.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+// sweep removes the buckets that are full again, they
+// are the same as a missing bucket.
+func (s *MemoryStore) sweep(now time.Time) {
+	for k, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.rate.Burst) {
+			delete(s.buckets, k)
+		}
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	b.tokens = math.Min(float64(b.rate.Burst), b.tokens+elapsed*b.rate.PerSecond())
+	b.last = now
+}
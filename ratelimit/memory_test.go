@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	rate := Rate{Burst: 2, Period: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		res, _ := s.Take("k", rate)
+		if !res.Allowed {
+			t.Fatalf("Expected take %d to be allowed", i)
+		}
+	}
+
+	res, _ := s.Take("k", rate)
+	if res.Allowed {
+		t.Fatal("Expected bucket to be empty")
+	}
+	if res.RetryAfter != 30*time.Second {
+		t.Errorf("Expected RetryAfter 30s. Recieved %s", res.RetryAfter)
+	}
+
+	now = now.Add(30 * time.Second)
+	if res, _ := s.Take("k", rate); !res.Allowed {
+		t.Error("Expected bucket to be refilled")
+	}
+}
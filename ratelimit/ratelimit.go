@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rate describes a token bucket. The bucket holds at most
+// Burst tokens and is refilled with Burst tokens every Period.
+type Rate struct {
+	Burst  int
+	Period time.Duration
+}
+
+// PerSecond returns the number of tokens added to the
+// bucket every second.
+func (r Rate) PerSecond() float64 {
+	return float64(r.Burst) / r.Period.Seconds()
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long the caller has to wait for the
+	// next token. It is zero when Allowed is true.
+	RetryAfter time.Duration
+}
+
+// Store is the backend keeping the state of the buckets.
+// MemoryStore works for a single instance, a shared backend
+// (e.g. redis) has to implement this interface so several
+// instances can share the same limits.
+type Store interface {
+	// Take removes one token from the bucket identified by key,
+	// creating a full bucket described by rate if there is none.
+	Take(key string, rate Rate) (Result, error)
+}
+
+// Limiter limits the number of actions per key, the key
+// usually being an IP address, an email or a user ID.
+type Limiter struct {
+	name  string
+	rate  Rate
+	store Store
+}
+
+// New creates a new Limiter. The name is used to namespace
+// the keys so different limiters can share the same store.
+func New(name string, rate Rate, store Store) *Limiter {
+	return &Limiter{
+		name:  name,
+		rate:  rate,
+		store: store,
+	}
+}
+
+func (l *Limiter) Name() string {
+	return l.name
+}
+
+func (l *Limiter) Rate() Rate {
+	return l.rate
+}
+
+// Allow takes a token for key.
+func (l *Limiter) Allow(key string) (Result, error) {
+	return l.store.Take(l.name+":"+key, l.rate)
+}
+
+// LimitError is a public error telling the user how long
+// they have to wait before trying again.
+type LimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e LimitError) Error() string {
+	return "ratelimit: too many requests"
+}
+
+func (e LimitError) Public() string {
+	return fmt.Sprintf("Too many attempts. Please wait %s before trying again.", Humanize(e.RetryAfter))
+}
+
+// Humanize rounds d up to the second or minute and
+// formats it for the user.
+func Humanize(d time.Duration) string {
+	if d <= time.Minute {
+		secs := int((d + time.Second - 1) / time.Second)
+		if secs <= 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", secs)
+	}
+
+	mins := int((d + time.Minute - 1) / time.Minute)
+	return fmt.Sprintf("%d minutes", mins)
+}
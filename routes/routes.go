@@ -1,30 +1,109 @@
 package routes
 
 import (
+	"expvar"
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
 	"soramon0/webapp/controllers"
+	"soramon0/webapp/email"
+	"soramon0/webapp/jobs"
+	"soramon0/webapp/lib"
 	"soramon0/webapp/middleware"
 	"soramon0/webapp/models"
+	"soramon0/webapp/oidc"
+	"soramon0/webapp/ratelimit"
+	"soramon0/webapp/utils"
+	"soramon0/webapp/webauthn"
 
 	"github.com/gorilla/mux"
 )
 
-func Register(s *models.Services, wg *sync.WaitGroup, l *log.Logger) *mux.Router {
+func Register(s *models.Services, wg *sync.WaitGroup, jr *jobs.Runner, l *log.Logger) *mux.Router {
 	r := mux.NewRouter()
 
+	// Limits are kept in memory, a shared ratelimit.Store
+	// is needed when running several instances.
+	ls := ratelimit.NewMemoryStore()
+
+	mailer := email.NewLogMailer(l)
+	if utils.GetSMTPHost() != "" {
+		mailer = email.NewSMTP(email.SMTPConfig{
+			Host:     utils.GetSMTPHost(),
+			Port:     utils.GetSMTPPort(),
+			Username: utils.GetSMTPUser(),
+			Password: utils.GetSMTPPassword(),
+			From:     utils.GetMailFrom(),
+		})
+	}
+
 	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(s.User, r, l)
-	galleriesC := controllers.NewGalleries(s.Gallery, s.Image, r, l)
+	usersC := controllers.NewUsers(s.User, s, ls, r, l)
+	galleriesC := controllers.NewGalleries(s.Gallery, s.Image, s, r, l)
+	rp := webauthn.New(webauthn.Config{
+		RPID:   utils.GetWebAuthnRPID(),
+		RPName: controllers.TOTPIssuer,
+		Origin: utils.GetWebAuthnOrigin(),
+	})
+	// Like the limits, passkey challenges are kept in memory.
+	passkeysC := controllers.NewPasskeys(s.Credential, usersC, rp, webauthn.NewMemoryStore(), r, l)
+	op := oidc.NewProvider(oidc.Config{
+		Name:         utils.GetOIDCName(),
+		Issuer:       utils.GetOIDCIssuer(),
+		ClientID:     utils.GetOIDCClientID(),
+		ClientSecret: utils.GetOIDCClientSecret(),
+		RedirectURL:  utils.GetOIDCRedirectURL(),
+		Scopes:       []string{"email", "profile"},
+	})
+	oidcC := controllers.NewOIDC(op, s.Identity, usersC, r, l)
+	magicLinksC := controllers.NewMagicLinks(mailer, usersC, r, l)
+	exportsC := controllers.NewExports(s.Export, jr, mailer, r, l)
+	adminC := controllers.NewAdmin(usersC, magicLinksC, s.Gallery, s.Image, s.Audit, s, r, l)
 
 	ar := middleware.NewAwaitRequest(wg)
-	um := middleware.NewUser(s.User)
+	rid := middleware.NewRequestID()
+	hmac, err := lib.NewHMACFromEnv()
+	utils.Must(err)
+	um := middleware.NewUser(s.User, hmac)
 	ru := middleware.NewRequireUser(*um)
+	ra := middleware.NewRequireRole(*ru, models.RoleAdmin)
+	rl := middleware.NewRateLimit(ls, map[string]ratelimit.Rate{
+		controllers.SignupURL:        {Burst: 5, Period: time.Hour},
+		controllers.MagicLinkURL:     {Burst: 5, Period: time.Hour},
+		controllers.GalleryCreateURL: {Burst: 30, Period: time.Hour},
+		controllers.ImageUploadURL:   {Burst: 60, Period: 10 * time.Minute},
+		controllers.ExportCreateURL:  {Burst: 3, Period: 24 * time.Hour},
+		// The account changes check the current password.
+		controllers.AccountUpdateURL:    {Burst: 10, Period: 10 * time.Minute},
+		controllers.AccountPasswordURL:  {Burst: 10, Period: 10 * time.Minute},
+		controllers.AccountDeleteURL:    {Burst: 10, Period: 10 * time.Minute},
+		controllers.TwoFactorDisableURL: {Burst: 10, Period: 10 * time.Minute},
+	})
+	sh := middleware.NewSecurityHeaders(middleware.SecurityConfig{
+		ContentSecurityPolicy: utils.GetCSP(),
+		HSTSMaxAge:            utils.GetHSTSMaxAge(),
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "strict-origin-when-cross-origin",
+		ContentTypeOptions:    "nosniff",
+	})
+	// User uploaded files must never be sniffed or run as a document,
+	// and anything that is not a known image type is downloaded instead.
+	imagesSh := sh.With(func(cfg *middleware.SecurityConfig) {
+		cfg.ContentSecurityPolicy = "default-src 'none'; img-src 'self'; sandbox"
+		cfg.InlineExts = []string{".jpg", ".jpeg", ".png", ".gif"}
+	})
+	r.Use(rid.Middleware)
+	r.Use(sh.Middleware)
 
 	// Serving images
-	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", http.FileServer(http.Dir("./images"))))
+	r.PathPrefix("/images/").Handler(imagesSh.Apply(http.StripPrefix("/images/", http.FileServer(http.Dir("./images")))))
+
+	// Serving metrics, they expose the internals of the
+	// process and are only readable with the token.
+	mt := middleware.NewMetricsToken(utils.GetMetricsToken())
+	r.Handle("/debug/vars", mt.Apply(expvar.Handler())).Methods(http.MethodGet)
 
 	// Serving assets
 	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir("./assets"))))
@@ -32,24 +111,64 @@ func Register(s *models.Services, wg *sync.WaitGroup, l *log.Logger) *mux.Router
 	baseR := r.NewRoute().Subrouter()
 	baseR.Use(ar.Middleware)
 	baseR.Use(um.Middleware)
+	baseR.Use(rl.Middleware)
 	baseR.Handle("/", staticC.HomeView).Methods(http.MethodGet)
 	baseR.Handle("/contact", staticC.ContactView).Methods(http.MethodGet)
 	baseR.Handle("/signup", usersC.SignupView).Methods(http.MethodGet)
-	baseR.HandleFunc("/signup", usersC.Signup).Methods(http.MethodPost)
-	baseR.Handle("/login", usersC.LoginView).Methods(http.MethodGet)
+	baseR.HandleFunc("/signup", usersC.Signup).Methods(http.MethodPost).Name(controllers.SignupURL)
+	baseR.HandleFunc("/login", usersC.LoginPage).Methods(http.MethodGet)
 	baseR.HandleFunc("/login", usersC.Login).Methods(http.MethodPost)
+	baseR.Handle("/login/2fa", usersC.TwoFactorView).Methods(http.MethodGet)
+	baseR.HandleFunc("/login/2fa", usersC.TwoFactor).Methods(http.MethodPost)
+	baseR.Handle("/login/magic", magicLinksC.NewView).Methods(http.MethodGet)
+	baseR.HandleFunc("/login/magic", magicLinksC.Create).Methods(http.MethodPost).Name(controllers.MagicLinkURL)
+	baseR.HandleFunc("/login/magic/callback", magicLinksC.Confirm).Methods(http.MethodGet)
+	baseR.HandleFunc("/login/magic/callback", magicLinksC.Callback).Methods(http.MethodPost)
+	baseR.HandleFunc("/auth/oidc/login", oidcC.Login).Methods(http.MethodGet)
+	baseR.HandleFunc("/auth/oidc/callback", oidcC.Callback).Methods(http.MethodGet)
+	baseR.HandleFunc("/webauthn/login/begin", passkeysC.BeginLogin).Methods(http.MethodPost)
+	baseR.HandleFunc("/webauthn/login/finish", passkeysC.FinishLogin).Methods(http.MethodPost)
 	baseR.HandleFunc("/galleries/{id:[0-9]+}", galleriesC.Show).Methods(http.MethodGet).Name(controllers.GalleryShowURL)
 
 	authR := baseR.NewRoute().Subrouter()
 	authR.Use(ru.Middleware)
+	authR.HandleFunc("/account", usersC.Account).Methods(http.MethodGet).Name(controllers.AccountURL)
+	authR.HandleFunc("/account", usersC.UpdateAccount).Methods(http.MethodPost).Name(controllers.AccountUpdateURL)
+	authR.HandleFunc("/account/password", usersC.ChangePassword).Methods(http.MethodPost).Name(controllers.AccountPasswordURL)
+	authR.HandleFunc("/account/delete", usersC.DeleteAccount).Methods(http.MethodPost).Name(controllers.AccountDeleteURL)
+	authR.HandleFunc("/account/export", exportsC.Index).Methods(http.MethodGet).Name(controllers.ExportsIndexURL)
+	authR.HandleFunc("/account/export", exportsC.Create).Methods(http.MethodPost).Name(controllers.ExportCreateURL)
+	authR.HandleFunc("/account/export/{id:[0-9]+}/download", exportsC.Download).Methods(http.MethodGet).Name(controllers.ExportDownloadURL)
+	authR.HandleFunc("/account/2fa", usersC.TwoFactorSetup).Methods(http.MethodGet)
+	authR.HandleFunc("/account/2fa/enable", usersC.EnableTwoFactor).Methods(http.MethodPost)
+	authR.HandleFunc("/account/2fa/disable", usersC.DisableTwoFactor).Methods(http.MethodPost).Name(controllers.TwoFactorDisableURL)
+	authR.HandleFunc("/account/passkeys", passkeysC.Index).Methods(http.MethodGet).Name(controllers.PasskeysIndexURL)
+	authR.HandleFunc("/account/passkeys/{id:[0-9]+}/delete", passkeysC.Delete).Methods(http.MethodPost)
+	authR.HandleFunc("/webauthn/register/begin", passkeysC.BeginRegistration).Methods(http.MethodPost)
+	authR.HandleFunc("/webauthn/register/finish", passkeysC.FinishRegistration).Methods(http.MethodPost)
 	authR.Handle("/galleries/new", galleriesC.NewView).Methods(http.MethodGet)
-	authR.HandleFunc("/galleries", galleriesC.Create).Methods(http.MethodPost)
+	authR.HandleFunc("/galleries", galleriesC.Create).Methods(http.MethodPost).Name(controllers.GalleryCreateURL)
 	authR.HandleFunc("/galleries", galleriesC.Index).Methods(http.MethodGet).Name(controllers.GalleriesIndexURL)
 	authR.HandleFunc("/galleries/{id:[0-9]+}/edit", galleriesC.Edit).Methods(http.MethodGet).Name(controllers.GalleryEditURL)
 	authR.HandleFunc("/galleries/{id:[0-9]+}/update", galleriesC.Update).Methods(http.MethodPost)
 	authR.HandleFunc("/galleries/{id:[0-9]+}/delete", galleriesC.Delete).Methods(http.MethodPost)
-	authR.HandleFunc("/galleries/{id:[0-9]+}/images", galleriesC.ImageUpload).Methods(http.MethodPost)
+	authR.HandleFunc("/galleries/trash", galleriesC.Trash).Methods(http.MethodGet).Name(controllers.GalleryTrashURL)
+	authR.HandleFunc("/galleries/{id:[0-9]+}/restore", galleriesC.Restore).Methods(http.MethodPost)
+	authR.HandleFunc("/galleries/{id:[0-9]+}/images", galleriesC.ImageUpload).Methods(http.MethodPost).Name(controllers.ImageUploadURL)
 	authR.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete", galleriesC.ImageDelete).Methods(http.MethodPost)
+	authR.HandleFunc("/impersonate/stop", adminC.StopImpersonating).Methods(http.MethodPost)
+
+	adminR := baseR.PathPrefix("/admin").Subrouter()
+	adminR.Use(ra.Middleware)
+	adminR.HandleFunc("", adminC.Dashboard).Methods(http.MethodGet).Name(controllers.AdminURL)
+	adminR.HandleFunc("/users", adminC.Users).Methods(http.MethodGet).Name(controllers.AdminUsersURL)
+	adminR.HandleFunc("/users/{id:[0-9]+}/disable", adminC.DisableUser).Methods(http.MethodPost)
+	adminR.HandleFunc("/users/{id:[0-9]+}/enable", adminC.EnableUser).Methods(http.MethodPost)
+	adminR.HandleFunc("/users/{id:[0-9]+}/reset-password", adminC.ResetPassword).Methods(http.MethodPost)
+	adminR.HandleFunc("/users/{id:[0-9]+}/impersonate", adminC.Impersonate).Methods(http.MethodPost)
+	adminR.HandleFunc("/galleries", adminC.Galleries).Methods(http.MethodGet).Name(controllers.AdminGalleriesURL)
+	adminR.HandleFunc("/galleries/{id:[0-9]+}/delete", adminC.DeleteGallery).Methods(http.MethodPost)
+	adminR.HandleFunc("/audit", adminC.Audit).Methods(http.MethodGet).Name(controllers.AdminAuditURL)
 
 	return r
 }
@@ -0,0 +1,147 @@
+package seed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+
+	"soramon0/webapp/models"
+)
+
+// DefaultPassword is the password of the seeded users. It
+// satisfies the password policy, and is only meant for
+// development databases.
+const DefaultPassword = "seeded-Lens-4827!"
+
+// Config is the volume of data to seed. The same Seed always
+// generates the same names, titles and images.
+type Config struct {
+	Users            int
+	GalleriesPerUser int
+	ImagesPerGallery int
+	Seed             int64
+	// Password of the users, DefaultPassword if empty.
+	Password string
+}
+
+// DefaultConfig is a small but realistic data set.
+var DefaultConfig = Config{
+	Users:            5,
+	GalleriesPerUser: 3,
+	ImagesPerGallery: 4,
+	Seed:             1,
+}
+
+// Result is what the seeder created.
+type Result struct {
+	Users     []models.User
+	Galleries []models.Gallery
+	Images    int
+}
+
+// Seeder creates users, galleries and placeholder images
+// through the services, so the data passes the validators
+// like data created in the app.
+type Seeder struct {
+	us  models.UserService
+	gs  models.GalleryService
+	is  models.ImageService
+	cfg Config
+}
+
+func New(us models.UserService, gs models.GalleryService, is models.ImageService, cfg Config) *Seeder {
+	if cfg.Password == "" {
+		cfg.Password = DefaultPassword
+	}
+
+	return &Seeder{us: us, gs: gs, is: is, cfg: cfg}
+}
+
+// Run seeds the data. The emails are numbered, seeding twice
+// without a reset fails with models.ErrEmailTaken.
+func (s *Seeder) Run(ctx context.Context) (*Result, error) {
+	r := rand.New(rand.NewSource(s.cfg.Seed))
+	var res Result
+	for i := 0; i < s.cfg.Users; i++ {
+		first, last := pick(r, firstNames), pick(r, lastNames)
+		user := models.User{
+			Name:     first + " " + last,
+			Email:    fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), i+1),
+			Password: s.cfg.Password,
+		}
+		if err := s.us.Create(ctx, &user); err != nil {
+			return &res, fmt.Errorf("seed: user %s: %w", user.Email, err)
+		}
+		res.Users = append(res.Users, user)
+
+		for j := 0; j < s.cfg.GalleriesPerUser; j++ {
+			gallery := models.Gallery{
+				UserID: user.ID,
+				Title:  pick(r, adjectives) + " " + pick(r, subjects),
+			}
+			if err := s.gs.Create(ctx, &gallery); err != nil {
+				return &res, fmt.Errorf("seed: gallery %q: %w", gallery.Title, err)
+			}
+			res.Galleries = append(res.Galleries, gallery)
+
+			for k := 0; k < s.cfg.ImagesPerGallery; k++ {
+				var buf bytes.Buffer
+				if err := png.Encode(&buf, Placeholder(r, 640, 480)); err != nil {
+					return &res, err
+				}
+				filename := fmt.Sprintf("placeholder-%02d.png", k+1)
+				if err := s.is.Create(ctx, gallery.ID, ioutil.NopCloser(&buf), filename); err != nil {
+					return &res, fmt.Errorf("seed: image %s: %w", filename, err)
+				}
+				res.Images++
+			}
+		}
+	}
+
+	return &res, nil
+}
+
+// Placeholder draws a w by h image, a diagonal gradient between
+// two random colors.
+func Placeholder(r *rand.Rand, w, h int) image.Image {
+	from, to := randomColor(r), randomColor(r)
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			t := float64(x+y) / float64(w+h)
+			img.Set(x, y, color.RGBA{
+				R: mix(from.R, to.R, t),
+				G: mix(from.G, to.G, t),
+				B: mix(from.B, to.B, t),
+				A: 255,
+			})
+		}
+	}
+
+	return img
+}
+
+func randomColor(r *rand.Rand) color.RGBA {
+	return color.RGBA{R: uint8(r.Intn(256)), G: uint8(r.Intn(256)), B: uint8(r.Intn(256)), A: 255}
+}
+
+func mix(a, b uint8, t float64) uint8 {
+	return uint8(float64(a)*(1-t) + float64(b)*t)
+}
+
+func pick(r *rand.Rand, words []string) string {
+	return words[r.Intn(len(words))]
+}
+
+var (
+	firstNames = []string{"Amelia", "Noah", "Olivia", "Liam", "Emma", "Lucas", "Sofia", "Hugo", "Yara", "Kenji", "Leila", "Mateo"}
+	lastNames  = []string{"Hughes", "Martin", "Rossi", "Nakamura", "Haddad", "Silva", "Dubois", "Novak", "Okafor", "Berg"}
+	adjectives = []string{"Summer", "Winter", "Autumn", "Spring", "Weekend", "Family", "Misty", "Golden", "Urban", "Coastal"}
+	subjects   = []string{"Holidays", "Hikes", "Portraits", "Landscapes", "Streets", "Wedding", "Road Trip", "Sunsets", "Gardens", "Harbour"}
+)
@@ -0,0 +1,106 @@
+package seed
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"soramon0/webapp/models"
+	"soramon0/webapp/password"
+	"soramon0/webapp/utils"
+
+	"github.com/nicholasjackson/env"
+)
+
+func TestPlaceholder(t *testing.T) {
+	a := Placeholder(rand.New(rand.NewSource(7)), 64, 48)
+	b := Placeholder(rand.New(rand.NewSource(7)), 64, 48)
+	if a.Bounds() != image.Rect(0, 0, 64, 48) {
+		t.Fatalf("Bounds = %v", a.Bounds())
+	}
+
+	for _, p := range []image.Point{{0, 0}, {32, 24}, {63, 47}} {
+		if a.At(p.X, p.Y) != b.At(p.X, p.Y) {
+			t.Errorf("pixel %v differs for the same seed", p)
+		}
+	}
+}
+
+func TestDefaultPassword(t *testing.T) {
+	// The default minimum of PASSWORD_MIN_ENTROPY.
+	if e := password.Entropy(DefaultPassword); e < 36 {
+		t.Errorf("Entropy(DefaultPassword) = %.1f, below the default policy", e)
+	}
+	if password.DefaultBreachedList().Contains(DefaultPassword) {
+		t.Error("DefaultPassword is in the breached list")
+	}
+}
+
+// seedMemory runs the seeder against in-memory services, with
+// the images in a temporary directory.
+func seedMemory(t *testing.T, cfg Config) (*Result, models.ImageService) {
+	us := models.NewUserServiceWithDB(models.NewMemoryUserDB(), nil)
+	gs := models.NewGalleryServiceWithDB(models.NewMemoryGalleryDB(), nil)
+	is := models.NewImageServiceWithDir(t.TempDir(), nil)
+
+	res, err := New(us, gs, is, cfg).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, is
+}
+
+func TestRun(t *testing.T) {
+	utils.Must(env.Parse())
+	ctx := context.Background()
+	cfg := Config{Users: 2, GalleriesPerUser: 2, ImagesPerGallery: 2, Seed: 7}
+
+	a, ais := seedMemory(t, cfg)
+	if len(a.Users) != 2 || len(a.Galleries) != 4 || a.Images != 8 {
+		t.Fatalf("Expected 2 users, 4 galleries and 8 images. Recieved %d, %d and %d",
+			len(a.Users), len(a.Galleries), a.Images)
+	}
+
+	b, bis := seedMemory(t, cfg)
+	for i := range a.Users {
+		if a.Users[i].Email != b.Users[i].Email {
+			t.Errorf("users[%d] = %s and %s for the same seed", i, a.Users[i].Email, b.Users[i].Email)
+		}
+	}
+	for i, g := range a.Galleries {
+		if g.Title != b.Galleries[i].Title {
+			t.Errorf("galleries[%d] = %q and %q for the same seed", i, g.Title, b.Galleries[i].Title)
+		}
+
+		images, err := ais.ByGalleryID(ctx, g.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(images) != cfg.ImagesPerGallery {
+			t.Fatalf("Expected %d images in gallery %d. Recieved %d", cfg.ImagesPerGallery, g.ID, len(images))
+		}
+		for _, img := range images {
+			other := models.Image{GalleryID: b.Galleries[i].ID, Filename: img.Filename}
+			if !bytes.Equal(readImage(t, ais, &img), readImage(t, bis, &other)) {
+				t.Errorf("image %s of gallery %d differs for the same seed", img.Filename, g.ID)
+			}
+		}
+	}
+}
+
+func readImage(t *testing.T, is models.ImageService, img *models.Image) []byte {
+	r, err := is.Open(context.Background(), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/nicholasjackson/env"
 )
@@ -10,14 +12,58 @@ var (
 	bindAddress = env.String("BIND_ADDRESS", false, "", "Bind address for the server")
 	bindPort    = env.String("BIND_PORT", false, "3000", "Bind port for the server")
 	pepper      = env.String("PEPPER", false, "+xylGoeVwEuZB7eUFZzOoElyXpweg8pRrFPxWqJV", "pepper used for password encryption")
+	peppers     = env.String("PEPPERS", false, "", "comma separated id:key peppers, the first one is used for new passwords. PEPPER is kept as pepper 0")
+	pwAlgorithm = env.String("PASSWORD_ALGORITHM", false, "argon2id", "password hashing algorithm, bcrypt or argon2id")
+	bcryptCost  = env.Int("BCRYPT_COST", false, 12, "bcrypt cost of new password hashes")
+	argonMemory = env.Int("ARGON2_MEMORY", false, 64*1024, "argon2id memory in KiB of new password hashes")
+	argonTime   = env.Int("ARGON2_TIME", false, 3, "argon2id iterations of new password hashes")
+	argonThread = env.Int("ARGON2_THREADS", false, 2, "argon2id parallelism of new password hashes")
+	pwEntropy   = env.Int("PASSWORD_MIN_ENTROPY", false, 36, "minimum estimated strength of new passwords, in bits")
+	breachedPws = env.String("BREACHED_PASSWORDS", false, "", "path of a breached password list, the list shipped with the binary is used when empty")
 	secret      = env.String("SECRET", false, "pDzM28sbPEuKWl4QWtEAUIAJhpxxpySTxJx96Gml", "secret used for remember tokens")
+	hmacKeys    = env.String("HMAC_KEYS", false, "", "comma separated id:key HMAC keys, the first one is used for new hashes. SECRET is kept as key 0")
+	hmacSecret  = env.Bool("HMAC_KEEP_SECRET", false, true, "keep SECRET as HMAC key 0 and verify the hashes made with it before HMAC_KEYS, set to false once they are rotated")
 	dbHost      = env.String("DB_HOST", false, "localhost", "database host, i.e. localhost")
 	dbPort      = env.String("DB_PORT", false, "5432", "database port, i.e. 5432")
 	dbName      = env.String("DB_NAME", false, "dev_db", "database name")
 	dbUser      = env.String("DB_USER", false, "sora", "database user")
 	dbPassword  = env.String("DB_PASSWORD", false, "sora_password", "database user password")
+	dbDriver    = env.String("DB_DRIVER", false, "postgres", "database driver, postgres or sqlite")
+	dbPath      = env.String("DB_PATH", false, "lenslocked.db", "SQLite database file, or :memory: for a database lost on exit")
+	csp         = env.String("CSP", false, defaultCSP, "Content-Security-Policy header, {nonce} is replaced with a per-request nonce")
+	hstsMaxAge  = env.Int("HSTS_MAX_AGE", false, 0, "Strict-Transport-Security max-age in seconds, 0 disables HSTS")
+	metrics     = env.String("METRICS_TOKEN", false, "", "bearer token needed to read /debug/vars, which is disabled when empty")
+	maxFailures = env.Int("LOGIN_MAX_FAILURES", false, 5, "failed logins before an account is temporarily locked")
+	totpKey     = env.String("TOTP_KEY", false, "6Vq0ZkMkT3yJcF7uWb8pXr2dLs9hNa4eGt1oYi5C", "key used to encrypt TOTP secrets")
+	rpID        = env.String("WEBAUTHN_RP_ID", false, "localhost", "WebAuthn relying party ID, the domain of the site")
+	rpOrigin    = env.String("WEBAUTHN_ORIGIN", false, "http://localhost:3000", "WebAuthn origin, the scheme, host and port of the site")
+	oidcName    = env.String("OIDC_NAME", false, "Company SSO", "name of the OpenID Connect provider shown on the login page")
+	oidcIssuer  = env.String("OIDC_ISSUER", false, "", "OpenID Connect issuer URL, single sign-on is disabled when empty")
+	oidcClient  = env.String("OIDC_CLIENT_ID", false, "", "OpenID Connect client ID")
+	oidcSecret  = env.String("OIDC_CLIENT_SECRET", false, "", "OpenID Connect client secret")
+	oidcRedir   = env.String("OIDC_REDIRECT_URL", false, "http://localhost:3000/auth/oidc/callback", "OpenID Connect redirect URL")
+	baseURL     = env.String("BASE_URL", false, "http://localhost:3000", "public URL of the site, used in emails")
+	smtpHost    = env.String("SMTP_HOST", false, "", "SMTP server host, emails are logged when empty")
+	smtpPort    = env.String("SMTP_PORT", false, "587", "SMTP server port")
+	smtpUser    = env.String("SMTP_USER", false, "", "SMTP username")
+	smtpPass    = env.String("SMTP_PASSWORD", false, "", "SMTP password")
+	mailFrom    = env.String("MAIL_FROM", false, "LensLocked <no-reply@localhost>", "sender of the emails")
+	lockout     = env.Duration("LOGIN_LOCKOUT", false, 15*time.Minute, "how long an account stays locked after too many failed logins")
+	deleteGrace = env.Duration("ACCOUNT_DELETION_GRACE", false, 30*24*time.Hour, "how long a deleted account can be restored by signing in again")
+	trashTTL    = env.Duration("GALLERY_TRASH_RETENTION", false, 30*24*time.Hour, "how long a deleted gallery can be restored before it is purged")
+	exportTTL   = env.Duration("EXPORT_TTL", false, 7*24*time.Hour, "how long a personal data export can be downloaded")
+	exportTime  = env.Duration("EXPORT_TIMEOUT", false, time.Hour, "how long building a personal data export may take, past it the export is failed")
+	purgeEvery  = env.Duration("PURGE_INTERVAL", false, time.Hour, "how often deleted accounts, trashed galleries and expired exports are purged")
+	queryTime   = env.Duration("QUERY_TIMEOUT", false, 10*time.Second, "how long a database query may take, past it it is cancelled")
 )
 
+const defaultCSP = "default-src 'self'; " +
+	"script-src 'self' 'nonce-{nonce}' ajax.googleapis.com maxcdn.bootstrapcdn.com; " +
+	"style-src 'self' maxcdn.bootstrapcdn.com; " +
+	"font-src 'self' maxcdn.bootstrapcdn.com; " +
+	"img-src 'self' data:; " +
+	"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
 func GetBindAdress() string {
 	return fmt.Sprintf("%s:%s", *bindAddress, *bindPort)
 }
@@ -26,10 +72,152 @@ func GetPepper() string {
 	return *pepper
 }
 
+func GetPeppers() string {
+	return *peppers
+}
+
+func GetPasswordAlgorithm() string {
+	return *pwAlgorithm
+}
+
+func GetBcryptCost() int {
+	return *bcryptCost
+}
+
+func GetArgon2() (memory, iterations, threads int) {
+	return *argonMemory, *argonTime, *argonThread
+}
+
+func GetPasswordMinEntropy() int {
+	return *pwEntropy
+}
+
+func GetBreachedPasswords() string {
+	return *breachedPws
+}
+
 func GetSecret() string {
 	return *secret
 }
 
+func GetHMACKeys() string {
+	return *hmacKeys
+}
+
+func GetHMACKeepSecret() bool {
+	return *hmacSecret
+}
+
+func GetTOTPKey() string {
+	return *totpKey
+}
+
+func GetWebAuthnRPID() string {
+	return *rpID
+}
+
+func GetWebAuthnOrigin() string {
+	return *rpOrigin
+}
+
+func GetOIDCName() string {
+	return *oidcName
+}
+
+func GetOIDCIssuer() string {
+	return *oidcIssuer
+}
+
+func GetOIDCClientID() string {
+	return *oidcClient
+}
+
+func GetOIDCClientSecret() string {
+	return *oidcSecret
+}
+
+func GetOIDCRedirectURL() string {
+	return *oidcRedir
+}
+
+func GetBaseURL() string {
+	return strings.TrimSuffix(*baseURL, "/")
+}
+
+func GetSMTPHost() string {
+	return *smtpHost
+}
+
+func GetSMTPPort() string {
+	return *smtpPort
+}
+
+func GetSMTPUser() string {
+	return *smtpUser
+}
+
+func GetSMTPPassword() string {
+	return *smtpPass
+}
+
+func GetMailFrom() string {
+	return *mailFrom
+}
+
+func GetDBDriver() string {
+	return *dbDriver
+}
+
+// GetDBPath returns the file of the SQLite database.
+func GetDBPath() string {
+	return *dbPath
+}
+
+// GetDB returns the DSN of the Postgres database.
 func GetDB() string {
 	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s", *dbHost, *dbUser, *dbPassword, *dbName, *dbPort)
 }
+
+func GetCSP() string {
+	return *csp
+}
+
+func GetHSTSMaxAge() int {
+	return *hstsMaxAge
+}
+
+func GetMetricsToken() string {
+	return *metrics
+}
+
+func GetLoginMaxFailures() int {
+	return *maxFailures
+}
+
+func GetLoginLockout() time.Duration {
+	return *lockout
+}
+
+func GetAccountDeletionGrace() time.Duration {
+	return *deleteGrace
+}
+
+func GetExportTTL() time.Duration {
+	return *exportTTL
+}
+
+func GetExportTimeout() time.Duration {
+	return *exportTime
+}
+
+func GetGalleryTrashRetention() time.Duration {
+	return *trashTTL
+}
+
+func GetPurgeInterval() time.Duration {
+	return *purgeEvery
+}
+
+func GetQueryTimeout() time.Duration {
+	return *queryTime
+}
@@ -23,9 +23,12 @@ type Alert struct {
 // Data is the top level structure that views expect data
 // to come in.
 type Data struct {
-	Alert *Alert
-	Yield interface{}
-	User  *models.User
+	Alert    *Alert
+	Yield    interface{}
+	User     *models.User
+	CSPNonce string
+	// Impersonator is the admin acting as User, if any.
+	Impersonator *models.User
 }
 
 func (d *Data) SetAlert(err error) {
@@ -56,6 +56,8 @@ func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{})
 	}
 
 	vd.User = context.User(r.Context())
+	vd.CSPNonce = context.CSPNonce(r.Context())
+	vd.Impersonator = context.Impersonator(r.Context())
 
 	var buf bytes.Buffer
 	if err := v.Template.ExecuteTemplate(&buf, v.Layout, vd); err != nil {
@@ -0,0 +1,87 @@
+package webauthn
+
+import (
+	"sync"
+	"time"
+)
+
+// Session is a ceremony in progress, kept server side until it
+// finishes or expires.
+type Session struct {
+	// Ceremony tells the registration and login sessions apart.
+	Ceremony string
+	// UserID is the user registering a credential, 0 when
+	// signing in.
+	UserID    uint
+	Challenge []byte
+	Expires   time.Time
+}
+
+// SessionStore keeps the sessions by ID. Sessions are taken out
+// of the store when used, so a challenge can only be used once.
+// A shared store (e.g. redis) has to implement this interface
+// so several instances can finish each other's ceremonies.
+type SessionStore interface {
+	Put(id string, s Session) error
+	// Take removes the session and returns it, ok is false
+	// if there is none or it expired.
+	Take(id string) (s Session, ok bool, err error)
+}
+
+// sweepEvery is the number of Put calls between two removals
+// of the expired sessions.
+const sweepEvery = 256
+
+// MemoryStore keeps the sessions in memory. It is safe for
+// concurrent use, but sessions are per process.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+	calls    int
+	now      func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]Session),
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryStore) Put(id string, session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.calls%sweepEvery == 0 {
+		s.sweep(s.now())
+	}
+
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *MemoryStore) Take(id string) (Session, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false, nil
+	}
+	delete(s.sessions, id)
+
+	if s.now().After(session.Expires) {
+		return Session{}, false, nil
+	}
+	return session, true, nil
+}
+
+// sweep removes the sessions of the abandoned ceremonies.
+func (s *MemoryStore) sweep(now time.Time) {
+	for id, session := range s.sessions {
+		if now.After(session.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package webauthn
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+
+	want := Session{Ceremony: "login", Challenge: []byte("challenge"), Expires: now.Add(time.Minute)}
+	s.Put("id", want)
+
+	got, ok, err := s.Take("id")
+	if err != nil || !ok {
+		t.Fatalf("Expected session to be found. Recieved %v, %v", ok, err)
+	}
+	if got.Ceremony != want.Ceremony || !bytes.Equal(got.Challenge, want.Challenge) {
+		t.Errorf("Expected %+v. Recieved %+v", want, got)
+	}
+
+	if _, ok, _ := s.Take("id"); ok {
+		t.Error("Expected session to be used only once")
+	}
+}
+
+func TestMemoryStoreExpired(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+
+	s.Put("id", Session{Expires: now.Add(time.Minute)})
+	now = now.Add(2 * time.Minute)
+
+	if _, ok, _ := s.Take("id"); ok {
+		t.Error("Expected expired session to be refused")
+	}
+	if len(s.sessions) != 0 {
+		t.Errorf("Expected expired session to be removed. Recieved %d", len(s.sessions))
+	}
+}
@@ -0,0 +1,477 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+
+	"soramon0/webapp/lib"
+)
+
+const (
+	// ChallengeBytes is the size of the random challenges.
+	ChallengeBytes = 32
+
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+
+	coseAlgES256 = -7
+	coseAlgRS256 = -257
+)
+
+var (
+	ErrInvalidResponse        = errors.New("webauthn: invalid response")
+	ErrChallenge              = errors.New("webauthn: challenge mismatch")
+	ErrOrigin                 = errors.New("webauthn: origin mismatch")
+	ErrRPID                   = errors.New("webauthn: relying party ID mismatch")
+	ErrUserNotVerified        = errors.New("webauthn: user was not verified")
+	ErrSignature              = errors.New("webauthn: invalid signature")
+	ErrSignCount              = errors.New("webauthn: signature counter did not increase, the authenticator may be cloned")
+	ErrUnsupportedKey         = errors.New("webauthn: unsupported public key")
+	ErrUnsupportedAttestation = errors.New("webauthn: unsupported attestation format")
+)
+
+// b64 is the encoding used by browsers for binary fields.
+var b64 = base64.RawURLEncoding
+
+// Config describes the relying party, i.e. our site.
+type Config struct {
+	// RPID is the domain of the site, e.g. "example.com".
+	RPID   string
+	RPName string
+	// Origin is the scheme, host and port the browser
+	// reports, e.g. "https://example.com".
+	Origin  string
+	Timeout time.Duration
+}
+
+// RelyingParty runs the registration and assertion ceremonies.
+type RelyingParty struct {
+	cfg Config
+}
+
+func New(cfg Config) *RelyingParty {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+	return &RelyingParty{cfg: cfg}
+}
+
+func (rp *RelyingParty) Timeout() time.Duration {
+	return rp.cfg.Timeout
+}
+
+// User is the user account a credential is registered for.
+// ID must not contain personal information.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE encoded public key.
+	PublicKey []byte
+	SignCount uint32
+}
+
+// Binary is a []byte marshaled to and from base64url JSON
+// strings, the way our JavaScript sends it.
+type Binary []byte
+
+func (b Binary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b64.EncodeToString(b))
+}
+
+func (b *Binary) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	d, err := b64.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*b = d
+	return nil
+}
+
+type rpEntity struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          Binary `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credParam struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credDescriptor struct {
+	Type string `json:"type"`
+	ID   Binary `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	RequireResident  bool   `json:"requireResidentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are passed to navigator.credentials.create.
+type CreationOptions struct {
+	PublicKey struct {
+		RP                     rpEntity               `json:"rp"`
+		User                   userEntity             `json:"user"`
+		Challenge              Binary                 `json:"challenge"`
+		PubKeyCredParams       []credParam            `json:"pubKeyCredParams"`
+		Timeout                int64                  `json:"timeout"`
+		ExcludeCredentials     []credDescriptor       `json:"excludeCredentials"`
+		AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+		Attestation            string                 `json:"attestation"`
+	} `json:"publicKey"`
+}
+
+// RequestOptions are passed to navigator.credentials.get.
+type RequestOptions struct {
+	PublicKey struct {
+		Challenge        Binary           `json:"challenge"`
+		Timeout          int64            `json:"timeout"`
+		RPID             string           `json:"rpId"`
+		AllowCredentials []credDescriptor `json:"allowCredentials"`
+		UserVerification string           `json:"userVerification"`
+	} `json:"publicKey"`
+}
+
+// AttestationResponse is the credential returned by
+// navigator.credentials.create.
+type AttestationResponse struct {
+	RawID    Binary `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Binary `json:"clientDataJSON"`
+		AttestationObject Binary `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the credential returned by
+// navigator.credentials.get.
+type AssertionResponse struct {
+	RawID    Binary `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Binary `json:"clientDataJSON"`
+		AuthenticatorData Binary `json:"authenticatorData"`
+		Signature         Binary `json:"signature"`
+		UserHandle        Binary `json:"userHandle"`
+	} `json:"response"`
+}
+
+// NewChallenge generates a random challenge. It has to be
+// kept server side until the ceremony finishes, see
+// SessionStore.
+func NewChallenge() ([]byte, error) {
+	return lib.Bytes(ChallengeBytes)
+}
+
+// CreationOptions returns the options to register a new
+// discoverable credential for user. exclude lists the
+// credentials the user already has.
+func (rp *RelyingParty) CreationOptions(user User, challenge []byte, exclude [][]byte) CreationOptions {
+	var o CreationOptions
+	pk := &o.PublicKey
+	pk.RP = rpEntity{ID: rp.cfg.RPID, Name: rp.cfg.RPName}
+	pk.User = userEntity{ID: user.ID, Name: user.Name, DisplayName: user.DisplayName}
+	pk.Challenge = challenge
+	pk.PubKeyCredParams = []credParam{
+		{Type: "public-key", Alg: coseAlgES256},
+		{Type: "public-key", Alg: coseAlgRS256},
+	}
+	pk.Timeout = rp.cfg.Timeout.Milliseconds()
+	pk.ExcludeCredentials = descriptors(exclude)
+	pk.AuthenticatorSelection = authenticatorSelection{
+		ResidentKey:      "required",
+		RequireResident:  true,
+		UserVerification: "required",
+	}
+	pk.Attestation = "none"
+	return o
+}
+
+// RequestOptions returns the options to authenticate. allow
+// may be empty to let the user pick a discoverable credential.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow [][]byte) RequestOptions {
+	var o RequestOptions
+	pk := &o.PublicKey
+	pk.Challenge = challenge
+	pk.Timeout = rp.cfg.Timeout.Milliseconds()
+	pk.RPID = rp.cfg.RPID
+	pk.AllowCredentials = descriptors(allow)
+	pk.UserVerification = "required"
+	return o
+}
+
+// VerifyRegistration checks the response of a registration
+// ceremony started with challenge and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, resp AttestationResponse) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, ErrInvalidResponse
+	}
+
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	v, _, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	format, _ := obj["fmt"].(string)
+	rawAuthData, _ := obj["authData"].([]byte)
+	attStmt, _ := obj["attStmt"].(map[interface{}]interface{})
+
+	ad, err := rp.parseAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if ad.flags&flagAttested == 0 {
+		return nil, ErrInvalidResponse
+	}
+	if !bytes.Equal(ad.credentialID, resp.RawID) {
+		return nil, ErrInvalidResponse
+	}
+
+	switch format {
+	case "none":
+	case "packed":
+		// Only self attestation is supported, the statement is
+		// signed with the credential key itself.
+		if _, ok := attStmt["x5c"]; ok {
+			return nil, ErrUnsupportedAttestation
+		}
+		sig, _ := attStmt["sig"].([]byte)
+		clientHash := sha256.Sum256(resp.Response.ClientDataJSON)
+		signed := append(append([]byte{}, rawAuthData...), clientHash[:]...)
+		if err := verifySignature(ad.publicKey, signed, sig); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedAttestation
+	}
+
+	if _, err := parsePublicKey(ad.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        ad.credentialID,
+		PublicKey: ad.publicKey,
+		SignCount: ad.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response of an authentication
+// ceremony started with challenge against the stored credential.
+// It returns the new signature counter to store.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, cred Credential, resp AssertionResponse) (uint32, error) {
+	if resp.Type != "public-key" || !bytes.Equal(cred.ID, resp.RawID) {
+		return 0, ErrInvalidResponse
+	}
+
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	ad, err := rp.parseAuthData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	clientHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientHash[:]...)
+	if err := verifySignature(cred.PublicKey, signed, resp.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators that don't implement a counter always send 0.
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+
+	return ad.signCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	var cd struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return ErrInvalidResponse
+	}
+
+	if cd.Type != typ {
+		return ErrInvalidResponse
+	}
+
+	c, err := b64.DecodeString(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(c, challenge) != 1 {
+		return ErrChallenge
+	}
+
+	if cd.Origin != rp.cfg.Origin {
+		return ErrOrigin
+	}
+
+	return nil
+}
+
+type authData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthData parses and checks the authenticator data
+// described in section 6.1 of the WebAuthn spec.
+func (rp *RelyingParty) parseAuthData(b []byte) (*authData, error) {
+	if len(b) < 37 {
+		return nil, ErrInvalidResponse
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.cfg.RPID))
+	if subtle.ConstantTimeCompare(b[:32], rpIDHash[:]) != 1 {
+		return nil, ErrRPID
+	}
+
+	ad := &authData{
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if ad.flags&flagUserPresent == 0 || ad.flags&flagUserVerified == 0 {
+		return nil, ErrUserNotVerified
+	}
+
+	if ad.flags&flagAttested == 0 {
+		return ad, nil
+	}
+
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidResponse
+	}
+	n := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < n {
+		return nil, ErrInvalidResponse
+	}
+	ad.credentialID = rest[:n]
+	rest = rest[n:]
+
+	_, ext, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, err
+	}
+	ad.publicKey = rest[:len(rest)-len(ext)]
+
+	return ad, nil
+}
+
+// parsePublicKey decodes a COSE_Key (RFC 8152) holding
+// an ES256 or RS256 public key.
+func parsePublicKey(cose []byte) (crypto.PublicKey, error) {
+	v, _, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	alg, _ := m[int64(3)].(int64)
+	switch alg {
+	case coseAlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrUnsupportedKey
+		}
+		return pub, nil
+	case coseAlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+func verifySignature(cose, data, sig []byte) error {
+	pub, err := parsePublicKey(cose)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.Sum256(data)
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, h[:], sig) {
+			return ErrSignature
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig); err != nil {
+			return ErrSignature
+		}
+	}
+
+	return nil
+}
+
+func descriptors(ids [][]byte) []credDescriptor {
+	d := make([]credDescriptor, len(ids))
+	for i, id := range ids {
+		d[i] = credDescriptor{Type: "public-key", ID: id}
+	}
+	return d
+}
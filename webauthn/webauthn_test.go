@@ -0,0 +1,196 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+// softAuthenticator is a software authenticator holding a
+// single ES256 credential, so ceremonies can be tested
+// without hardware.
+type softAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	credID    []byte
+	signCount uint32
+	rpID      string
+	origin    string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	rand.Read(credID)
+	return &softAuthenticator{key: key, credID: credID, rpID: testRPID, origin: testOrigin}
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge []byte) []byte {
+	b, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": b64.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	return b
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	a.signCount++
+	h := sha256.Sum256([]byte(a.rpID))
+	b := append([]byte{}, h[:]...)
+	flags := byte(flagUserPresent | flagUserVerified)
+	if attested {
+		flags |= flagAttested
+	}
+	b = append(b, flags)
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], a.signCount)
+	b = append(b, count[:]...)
+	if attested {
+		b = append(b, make([]byte, 16)...) // AAGUID
+		b = append(b, byte(len(a.credID)>>8), byte(len(a.credID)))
+		b = append(b, a.credID...)
+		b = append(b, a.coseKey()...)
+	}
+	return b
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	return encodeCBOR(map[interface{}]interface{}{
+		int64(1):  int64(2),
+		int64(3):  int64(coseAlgES256),
+		int64(-1): int64(1),
+		int64(-2): x,
+		int64(-3): y,
+	})
+}
+
+func (a *softAuthenticator) create(challenge []byte) AttestationResponse {
+	var r AttestationResponse
+	r.RawID = a.credID
+	r.Type = "public-key"
+	r.Response.ClientDataJSON = a.clientData("webauthn.create", challenge)
+	r.Response.AttestationObject = encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authData(true),
+	})
+	return r
+}
+
+func (a *softAuthenticator) get(t *testing.T, challenge []byte) AssertionResponse {
+	var r AssertionResponse
+	r.RawID = a.credID
+	r.Type = "public-key"
+	r.Response.ClientDataJSON = a.clientData("webauthn.get", challenge)
+	r.Response.AuthenticatorData = a.authData(false)
+	h := sha256.Sum256(r.Response.ClientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, r.Response.AuthenticatorData...), h[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Response.Signature = sig
+	return r
+}
+
+// encodeCBOR supports just what the tests need.
+func encodeCBOR(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+		}
+	}
+
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		b := head(5, uint64(len(v)))
+		for k, e := range v {
+			b = append(b, encodeCBOR(k)...)
+			b = append(b, encodeCBOR(e)...)
+		}
+		return b
+	}
+	panic("encodeCBOR: unsupported type")
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	rp := New(Config{RPID: testRPID, RPName: "Test", Origin: testOrigin})
+	a := newSoftAuthenticator(t)
+
+	challenge, _ := NewChallenge()
+	cred, err := rp.VerifyRegistration(challenge, a.create(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge, _ = NewChallenge()
+	count, err := rp.VerifyAssertion(challenge, *cred, a.get(t, challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Expected sign count 2. Recieved %d", count)
+	}
+}
+
+func TestAssertionErrors(t *testing.T) {
+	rp := New(Config{RPID: testRPID, RPName: "Test", Origin: testOrigin})
+	a := newSoftAuthenticator(t)
+	challenge, _ := NewChallenge()
+	cred, err := rp.VerifyRegistration(challenge, a.create(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := NewChallenge()
+	if _, err := rp.VerifyAssertion(other, *cred, a.get(t, challenge)); err != ErrChallenge {
+		t.Errorf("Expected ErrChallenge. Recieved %v", err)
+	}
+
+	resp := a.get(t, challenge)
+	resp.Response.Signature[len(resp.Response.Signature)-1] ^= 0xff
+	if _, err := rp.VerifyAssertion(challenge, *cred, resp); err != ErrSignature {
+		t.Errorf("Expected ErrSignature. Recieved %v", err)
+	}
+
+	cloned := *cred
+	cloned.SignCount = 100
+	if _, err := rp.VerifyAssertion(challenge, cloned, a.get(t, challenge)); err != ErrSignCount {
+		t.Errorf("Expected ErrSignCount. Recieved %v", err)
+	}
+
+	a.origin = "https://evil.example"
+	if _, err := rp.VerifyAssertion(challenge, *cred, a.get(t, challenge)); err != ErrOrigin {
+		t.Errorf("Expected ErrOrigin. Recieved %v", err)
+	}
+}